@@ -0,0 +1,286 @@
+package goutil
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cacheSpill is the disk tier of a cache, for items moved out of memory
+type cacheSpill[K Hashable] struct {
+	dir   string
+	lock  *os.File
+	quota int64
+	size  int64
+	files map[K]cacheSpillFile
+}
+
+// cacheSpillFile is a cache item that was moved to disk
+type cacheSpillFile struct {
+	path    string
+	size    int64
+	lastUse time.Time
+	expAt   time.Time
+}
+
+// initSpill sets up the disk tier of a cache
+//
+// each cache spills into its own subdirectory of dir, which is held by a lock file,
+// so caches in this or another process can share the same dir without removing each others files
+//
+// any subdirectories left behind by a previous process are removed,
+// since the index of spilled items is only kept in memory
+func (cache *CacheMap[K, V]) initSpill(dir string, quota int64) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+
+	// hold the dir lock while cleaning up, so a new subdirectory is never mistaken for an old one
+	dirLock, err := lockFile(filepath.Join(dir, ".lock"), true)
+	if err != nil {
+		return
+	}
+	defer dirLock.Close()
+
+	removeStaleSpill(dir)
+
+	lock, err := os.CreateTemp(dir, "cache-*.lock")
+	if err != nil {
+		return
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lock.Close()
+		os.Remove(lock.Name())
+		return
+	}
+
+	sub := strings.TrimSuffix(lock.Name(), ".lock")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		lock.Close()
+		os.Remove(lock.Name())
+		return
+	}
+
+	cache.spill = &cacheSpill[K]{
+		dir:   sub,
+		lock:  lock,
+		quota: quota,
+		files: map[K]cacheSpillFile{},
+	}
+}
+
+// closeSpill removes all items from disk, and releases the subdirectory of the disk tier
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) closeSpill() {
+	if cache.spill == nil {
+		return
+	}
+
+	cache.clearSpill()
+	os.RemoveAll(cache.spill.dir)
+	os.Remove(cache.spill.lock.Name())
+	cache.spill.lock.Close()
+	cache.spill = nil
+}
+
+// removeStaleSpill removes the subdirectories of a spill dir, that are no longer held by a cache
+//
+// spill files from older versions, that were written to the dir itself, are also removed
+//
+// note: the dir lock must already be held
+func removeStaleSpill(dir string) {
+	if files, err := filepath.Glob(filepath.Join(dir, "*.spill")); err == nil {
+		for _, file := range files {
+			os.Remove(file)
+		}
+	}
+
+	locks, err := filepath.Glob(filepath.Join(dir, "cache-*.lock"))
+	if err != nil {
+		return
+	}
+
+	for _, path := range locks {
+		lock, err := lockFile(path, false)
+		if err != nil {
+			// still held by a cache
+			continue
+		}
+
+		os.RemoveAll(strings.TrimSuffix(path, ".lock"))
+		os.Remove(path)
+		lock.Close()
+	}
+}
+
+// lockFile opens a file and takes an exclusive lock on it
+//
+// the lock is released when the file is closed
+func lockFile(path string, wait bool) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+
+	if err := syscall.Flock(int(file.Fd()), how); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// spillKey moves a cache item from memory to disk
+//
+// the item is left in memory, and should be cleared by the caller if this returns nil
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) spillKey(key K) error {
+	path, err := JoinPath(cache.spill.dir, spillFileName(key))
+	if err != nil {
+		return err
+	}
+
+	cache.unspill(key)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	buf := bufio.NewWriter(file)
+	item := cache.snapshotItem(key)
+	if err := cache.codec.Encode(buf, &item); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+
+	if err := buf.Flush(); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+
+	size, _ := file.Seek(0, 1)
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	cache.spill.files[key] = cacheSpillFile{
+		path:    path,
+		size:    size,
+		lastUse: item.LastUse,
+		expAt:   item.ExpAt,
+	}
+	cache.spill.size += size
+
+	cache.spillQuota()
+
+	return nil
+}
+
+// loadSpilled moves a cache item from disk back into memory
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) loadSpilled(key K, now time.Time) {
+	spilled, ok := cache.spill.files[key]
+	if !ok {
+		return
+	}
+
+	file, err := os.Open(spilled.path)
+	if err != nil {
+		cache.unspill(key)
+		return
+	}
+
+	item := cacheSnapshotItem[K, V]{}
+	err = cache.codec.Decode(bufio.NewReader(file), &item)
+	file.Close()
+	cache.unspill(key)
+
+	if err != nil || item.Key != key {
+		return
+	}
+
+	cache.restoreItem(item, now)
+}
+
+// unspill removes a cache item from disk
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) unspill(key K) {
+	if spilled, ok := cache.spill.files[key]; ok {
+		os.Remove(spilled.path)
+		cache.spill.size -= spilled.size
+		delete(cache.spill.files, key)
+		cache.untag(key)
+	}
+}
+
+// spillQuota removes the least recently used items from disk, until the disk tier is within its quota
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) spillQuota() {
+	for cache.spill.quota > 0 && cache.spill.size > cache.spill.quota && len(cache.spill.files) != 0 {
+		var victim K
+		var lastUse time.Time
+		found := false
+
+		for key, spilled := range cache.spill.files {
+			if !found || spilled.lastUse.Before(lastUse) {
+				victim = key
+				lastUse = spilled.lastUse
+				found = true
+			}
+		}
+
+		cache.unspill(victim)
+		cache.stats.evictions[EVICT_CAPACITY]++
+	}
+}
+
+// sweepSpilled removes items from disk that have expired, or have not been accessed within the cacheTime
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) sweepSpilled(cacheTime time.Duration, now time.Time) {
+	for key, spilled := range cache.spill.files {
+		if (!spilled.expAt.IsZero() && now.After(spilled.expAt)) || now.Sub(spilled.lastUse) > cacheTime {
+			cache.unspill(key)
+			cache.stats.evictions[EVICT_EXPIRED]++
+		}
+	}
+}
+
+// clearSpill removes all items from disk
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) clearSpill() {
+	if cache.spill == nil {
+		return
+	}
+
+	for key := range cache.spill.files {
+		cache.unspill(key)
+	}
+}
+
+// spillFileName returns a file name for a cache key, that is safe to use on any file system
+func spillFileName[K Hashable](key K) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%T:%v", key, key)))
+	return hex.EncodeToString(sum[:]) + ".spill"
+}
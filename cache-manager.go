@@ -0,0 +1,261 @@
+package goutil
+
+import (
+	"sync"
+	"time"
+)
+
+// CacheThreshold sets how long cache items are kept, when the free memory crosses a limit
+type CacheThreshold struct {
+	// FreeMB is the free memory limit in megabytes
+	FreeMB float64
+
+	// Above applies the threshold when the free memory is above FreeMB, instead of below it
+	//
+	// thresholds above a limit will never shorten the ttl of a cache
+	Above bool
+
+	// TTL removes cache items that have not been accessed within this duration
+	TTL time.Duration
+}
+
+// ManagedCache is a cache that can be swept by the `CacheManager`
+type ManagedCache interface {
+	sweep(cacheTime time.Duration, lowMemory bool)
+	purge()
+}
+
+type cacheManager struct {
+	mu         sync.Mutex
+	caches     map[ManagedCache]struct{}
+	interval   time.Duration
+	thresholds []CacheThreshold
+	purgeMB    float64
+	purgeDelay time.Duration
+	stop       chan struct{}
+	reset      chan struct{}
+}
+
+// CacheManager removes old cache items from every cache created by `NewCache`,
+// based on how much free memory the system has left
+//
+// the free memory is limited by the cgroup memory limit, when running in a container
+var CacheManager *cacheManager = &cacheManager{
+	caches:   map[ManagedCache]struct{}{},
+	interval: 10 * time.Minute,
+	thresholds: []CacheThreshold{
+		// low memory: remove cache items have not been accessed in over 10 minutes
+		{FreeMB: 200, TTL: 10 * time.Minute},
+		// low memory: remove cache items have not been accessed in over 30 minutes
+		{FreeMB: 500, TTL: 30 * time.Minute},
+		// low memory: remove cache items have not been accessed in over 1 hour
+		{FreeMB: 2000, TTL: 1 * time.Hour},
+		// high memory: remove cache items have not been accessed in over 12 hour
+		{FreeMB: 64000, Above: true, TTL: 12 * time.Hour},
+		// high memory: remove cache items have not been accessed in over 6 hour
+		{FreeMB: 32000, Above: true, TTL: 6 * time.Hour},
+		// high memory: remove cache items have not been accessed in over 3 hour
+		{FreeMB: 16000, Above: true, TTL: 3 * time.Hour},
+	},
+	purgeMB:    10,
+	purgeDelay: 10 * time.Second,
+	reset:      make(chan struct{}, 1),
+}
+
+func init() {
+	CacheManager.Start()
+}
+
+// Start runs the memory sweeper in the background
+//
+// the sweeper is started by default, so this is only needed after calling `Stop`
+func (manager *cacheManager) Start() {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if manager.stop != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	manager.stop = stop
+
+	go func() {
+		for {
+			manager.mu.Lock()
+			interval := manager.interval
+			manager.mu.Unlock()
+
+			select {
+			case <-stop:
+				return
+			case <-manager.reset:
+				continue
+			case <-time.After(interval):
+			}
+
+			manager.sweep()
+
+			manager.mu.Lock()
+			purgeDelay := manager.purgeDelay
+			manager.mu.Unlock()
+
+			select {
+			case <-stop:
+				return
+			case <-time.After(purgeDelay):
+			}
+
+			manager.purge()
+		}
+	}()
+}
+
+// Stop stops the memory sweeper
+func (manager *cacheManager) Stop() {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if manager.stop != nil {
+		close(manager.stop)
+		manager.stop = nil
+	}
+}
+
+// Sweep checks the free memory and removes old cache items right away
+func (manager *cacheManager) Sweep() {
+	manager.sweep()
+	manager.purge()
+}
+
+// SetInterval sets how often the memory sweeper runs
+//
+// default: 10 minutes
+func (manager *cacheManager) SetInterval(interval time.Duration) {
+	manager.mu.Lock()
+	manager.interval = interval
+	manager.mu.Unlock()
+
+	select {
+	case manager.reset <- struct{}{}:
+	default:
+	}
+}
+
+// SetThresholds sets the free memory limits, and how long cache items are kept when they are crossed
+//
+// thresholds are checked in order, and the first one that matches is used
+//
+// default:
+//   - below 200MB: 10 minutes
+//   - below 500MB: 30 minutes
+//   - below 2GB: 1 hour
+//   - above 64GB: 12 hours
+//   - above 32GB: 6 hours
+//   - above 16GB: 3 hours
+func (manager *cacheManager) SetThresholds(thresholds []CacheThreshold) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.thresholds = append([]CacheThreshold{}, thresholds...)
+}
+
+// Thresholds returns a copy of the current thresholds
+func (manager *cacheManager) Thresholds() []CacheThreshold {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	return append([]CacheThreshold{}, manager.thresholds...)
+}
+
+// SetPurge sets the free memory limit, where all cache items are removed
+//
+// the limit is checked after a delay, once the thresholds have been applied
+//
+// default: 10MB, checked 10 seconds after each sweep
+func (manager *cacheManager) SetPurge(freeMB float64, delay time.Duration) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.purgeMB = freeMB
+	manager.purgeDelay = delay
+}
+
+// Register adds a cache to the memory sweeper
+//
+// caches created by `NewCache` are registered by default
+func (manager *cacheManager) Register(cache ManagedCache) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.caches[cache] = struct{}{}
+}
+
+// Unregister removes a cache from the memory sweeper
+func (manager *cacheManager) Unregister(cache ManagedCache) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	delete(manager.caches, cache)
+}
+
+// FreeMemory returns the amount of memory available in megabytes,
+// limited by the cgroup memory limit if there is one
+func (manager *cacheManager) FreeMemory() float64 {
+	mb := SysFreeMemory()
+	if cg, ok := CgroupFreeMemory(); ok && (mb == 0 || cg < mb) {
+		return cg
+	}
+	return mb
+}
+
+// list returns the registered caches
+func (manager *cacheManager) list() []ManagedCache {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	caches := make([]ManagedCache, 0, len(manager.caches))
+	for cache := range manager.caches {
+		caches = append(caches, cache)
+	}
+	return caches
+}
+
+// sweep removes old items from every cache, by the first threshold that matches the free memory
+func (manager *cacheManager) sweep() {
+	mb := manager.FreeMemory()
+
+	var cacheTime time.Duration
+	lowMemory := false
+
+	manager.mu.Lock()
+	for _, threshold := range manager.thresholds {
+		if mb == 0 {
+			break
+		}
+
+		if (threshold.Above && mb > threshold.FreeMB) || (!threshold.Above && mb < threshold.FreeMB) {
+			cacheTime = threshold.TTL
+			lowMemory = !threshold.Above
+			break
+		}
+	}
+	manager.mu.Unlock()
+
+	for _, cache := range manager.list() {
+		cache.sweep(cacheTime, lowMemory)
+	}
+}
+
+// purge removes all items from every cache, if the free memory is below the purge limit
+func (manager *cacheManager) purge() {
+	manager.mu.Lock()
+	purgeMB := manager.purgeMB
+	manager.mu.Unlock()
+
+	if mb := manager.FreeMemory(); mb < purgeMB && mb != 0 {
+		for _, cache := range manager.list() {
+			cache.purge()
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package goutil
+
+import (
+	"container/heap"
+	"sync/atomic"
+	"time"
+)
+
+// cacheEntry is the usage of a cache item, that decides when it expires and when it gets evicted
+type cacheEntry[K Hashable] struct {
+	key     K
+	created time.Time
+
+	// lastUse (in unix nanoseconds) and freq are atomic,
+	// so reads can update them while the cache is only read locked
+	lastUse atomic.Int64
+	freq    atomic.Uint64
+
+	// sortUse and sortFreq are the values the entry was last sorted by in the eviction queue
+	//
+	// they only change while the cache is write locked, and are never ahead of lastUse and freq
+	sortUse  int64
+	sortFreq uint64
+
+	// index is the position of the entry in the eviction queue
+	index int
+}
+
+// use marks an entry as used
+//
+// this is safe to call while the cache is only read locked
+func (entry *cacheEntry[K]) use(now time.Time) {
+	entry.lastUse.Store(now.UnixNano())
+	entry.freq.Add(1)
+}
+
+// resort updates the values the entry is sorted by, and returns true if they changed
+//
+// note: the cache must already be locked for writing
+func (entry *cacheEntry[K]) resort() bool {
+	lastUse, freq := entry.lastUse.Load(), entry.freq.Load()
+	if lastUse == entry.sortUse && freq == entry.sortFreq {
+		return false
+	}
+
+	entry.sortUse, entry.sortFreq = lastUse, freq
+	return true
+}
+
+// cacheQueue is a min heap of cache entries, ordered by the cache policy,
+// so the next item to evict is always first
+//
+// entries used while the cache is read locked are sorted lazily, once they reach the front of the queue
+type cacheQueue[K Hashable] struct {
+	list   []*cacheEntry[K]
+	policy CachePolicy
+}
+
+func (queue *cacheQueue[K]) Len() int {
+	return len(queue.list)
+}
+
+func (queue *cacheQueue[K]) Less(i, j int) bool {
+	a, b := queue.list[i], queue.list[j]
+
+	switch queue.policy {
+	case CACHE_LFU:
+		if a.sortFreq != b.sortFreq {
+			return a.sortFreq < b.sortFreq
+		}
+		return a.sortUse < b.sortUse
+	case CACHE_FIFO:
+		return a.created.Before(b.created)
+	default:
+		return a.sortUse < b.sortUse
+	}
+}
+
+func (queue *cacheQueue[K]) Swap(i, j int) {
+	queue.list[i], queue.list[j] = queue.list[j], queue.list[i]
+	queue.list[i].index = i
+	queue.list[j].index = j
+}
+
+func (queue *cacheQueue[K]) Push(x any) {
+	entry := x.(*cacheEntry[K])
+	entry.index = len(queue.list)
+	queue.list = append(queue.list, entry)
+}
+
+func (queue *cacheQueue[K]) Pop() any {
+	n := len(queue.list) - 1
+	entry := queue.list[n]
+	queue.list[n] = nil
+	queue.list = queue.list[:n]
+	entry.index = -1
+	return entry
+}
+
+// next returns the next entry to evict, other than the kept key
+//
+// returns nil if there are no other entries
+//
+// note: the cache must already be locked for writing
+func (queue *cacheQueue[K]) next(keep K) *cacheEntry[K] {
+	var kept *cacheEntry[K]
+	defer func() {
+		if kept != nil {
+			heap.Push(queue, kept)
+		}
+	}()
+
+	for len(queue.list) != 0 {
+		entry := queue.list[0]
+
+		// usage only moves an entry back in the queue,
+		// so the first entry is only the next to evict once it is up to date
+		if entry.resort() {
+			heap.Fix(queue, 0)
+			continue
+		}
+
+		if entry.key == keep {
+			kept = heap.Pop(queue).(*cacheEntry[K])
+			continue
+		}
+
+		return entry
+	}
+
+	return nil
+}
+
+// addEntry adds a new item to the eviction queue
+//
+// note: the cache must already be locked for writing
+func (cache *CacheMap[K, V]) addEntry(key K, now time.Time) *cacheEntry[K] {
+	entry := &cacheEntry[K]{key: key, created: now}
+	entry.lastUse.Store(now.UnixNano())
+	entry.resort()
+
+	cache.entry[key] = entry
+	heap.Push(&cache.queue, entry)
+	return entry
+}
+
+// useEntry marks an item as used, and moves it in the eviction queue
+//
+// note: the cache must already be locked for writing
+func (cache *CacheMap[K, V]) useEntry(entry *cacheEntry[K], now time.Time) {
+	entry.use(now)
+	cache.fixEntry(entry)
+}
+
+// fixEntry moves an item in the eviction queue, after its usage was changed
+//
+// note: the cache must already be locked for writing
+func (cache *CacheMap[K, V]) fixEntry(entry *cacheEntry[K]) {
+	entry.resort()
+	heap.Fix(&cache.queue, entry.index)
+}
+
+// removeEntry removes an item from the eviction queue
+//
+// note: the cache must already be locked for writing
+func (cache *CacheMap[K, V]) removeEntry(key K) {
+	if entry, ok := cache.entry[key]; ok {
+		heap.Remove(&cache.queue, entry.index)
+		delete(cache.entry, key)
+	}
+}
+
+// lastUse returns the last time an item was used, or the zero time if it does not exist
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) lastUse(key K) time.Time {
+	if entry, ok := cache.entry[key]; ok {
+		return time.Unix(0, entry.lastUse.Load())
+	}
+	return time.Time{}
+}
@@ -0,0 +1,331 @@
+package goutil
+
+import (
+	"hash/maphash"
+	"iter"
+	"math"
+	"time"
+)
+
+// ShardedCache is a `CacheMap` split into multiple shards, each with its own lock
+//
+// keys are hashed across the shards, so concurrent calls for different keys
+// rarely wait on each other
+type ShardedCache[K Hashable, V any] struct {
+	shards []*CacheMap[K, V]
+	seed   maphash.Seed
+	name   string
+}
+
+// NewShardedCache creates a new cache map, that is split into multiple shards
+// for high concurrency workloads
+//
+// @shards: the number of shards to split the cache into (default: 16)
+//
+// @exp: remove items that have not been accessed within this duration (0 = never)
+//
+// @opts: optional settings for all shards
+//   - MaxSize and MaxCost are divided between the shards
+//   - Name registers the sharded cache as a whole
+//   - SnapshotFile is not supported, use `Snapshot` on each shard with `ForEachShard` instead
+func NewShardedCache[K Hashable, V any](shards int, exp time.Duration, opts ...CacheOptions[K, V]) *ShardedCache[K, V] {
+	if shards <= 0 {
+		shards = 16
+	}
+
+	var opt CacheOptions[K, V]
+	if len(opts) != 0 {
+		opt = opts[0]
+	}
+
+	name := opt.Name
+	opt.Name = ""
+	opt.SnapshotFile = ""
+
+	if opt.MaxSize > 0 {
+		opt.MaxSize = int(math.Ceil(float64(opt.MaxSize) / float64(shards)))
+	}
+	if opt.MaxCost > 0 {
+		opt.MaxCost = int64(math.Ceil(float64(opt.MaxCost) / float64(shards)))
+	}
+
+	cache := ShardedCache[K, V]{
+		shards: make([]*CacheMap[K, V], shards),
+		seed:   maphash.MakeSeed(),
+		name:   name,
+	}
+
+	for i := range cache.shards {
+		cache.shards[i] = NewCache(exp, opt)
+	}
+
+	if name != "" {
+		RegisterCache(name, &cache)
+	}
+
+	return &cache
+}
+
+// Get returns a value or an error if it exists
+//
+// if the object key does not exist, it will return both a nil/zero value (of the relevant type) and nil error
+func (cache *ShardedCache[K, V]) Get(key K) (V, error) {
+	return cache.shard(key).Get(key)
+}
+
+// GetOrLoad returns a value or an error if it exists,
+// or runs the loader and caches its result if it does not
+func (cache *ShardedCache[K, V]) GetOrLoad(key K, loader func(key K) (V, error)) (V, error) {
+	return cache.shard(key).GetOrLoad(key, loader)
+}
+
+// Set sets or adds a new key with either a value, or an error
+func (cache *ShardedCache[K, V]) Set(key K, value V, err error) {
+	cache.shard(key).Set(key, value, err)
+}
+
+// SetWithTTL sets or adds a new key with a value, that expires
+// if it has not been accessed within the ttl
+func (cache *ShardedCache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	cache.shard(key).SetWithTTL(key, value, ttl)
+}
+
+// SetWithDeadline sets or adds a new key with a value, that expires
+// at a fixed time, no matter how often it is accessed
+func (cache *ShardedCache[K, V]) SetWithDeadline(key K, value V, deadline time.Time) {
+	cache.shard(key).SetWithDeadline(key, value, deadline)
+}
+
+// SetTagged sets or adds a new key with either a value, or an error,
+// and tags it so it can be removed with `InvalidateTag`
+func (cache *ShardedCache[K, V]) SetTagged(key K, value V, err error, tags ...string) {
+	cache.shard(key).SetTagged(key, value, err, tags...)
+}
+
+// InvalidateTag removes every cache item with a tag
+//
+// returns the number of items removed
+func (cache *ShardedCache[K, V]) InvalidateTag(tag string) int {
+	n := 0
+	for _, shard := range cache.shards {
+		n += shard.InvalidateTag(tag)
+	}
+	return n
+}
+
+// DelPrefix removes every cache item with a string key that starts with a prefix
+//
+// returns the number of items removed
+func (cache *ShardedCache[K, V]) DelPrefix(prefix string) int {
+	n := 0
+	for _, shard := range cache.shards {
+		n += shard.DelPrefix(prefix)
+	}
+	return n
+}
+
+// Del removes a cache item by key
+func (cache *ShardedCache[K, V]) Del(key K) {
+	cache.shard(key).Del(key)
+}
+
+// DelOld removes old cache items
+//
+// @cacheTime: remove items that have not been accessed within this duration (0 = remove all items)
+func (cache *ShardedCache[K, V]) DelOld(cacheTime time.Duration) {
+	for _, shard := range cache.shards {
+		shard.DelOld(cacheTime)
+	}
+}
+
+// Has returns true if a key value exists and is not an error
+func (cache *ShardedCache[K, V]) Has(key K) bool {
+	return cache.shard(key).Has(key)
+}
+
+// Expire sets the ttl for all cache items
+func (cache *ShardedCache[K, V]) Expire(exp time.Duration) bool {
+	for _, shard := range cache.shards {
+		shard.Expire(exp)
+	}
+
+	return false
+}
+
+// Touch resets a cache items expiration so it will stay in the cache longer
+func (cache *ShardedCache[K, V]) Touch(key K) bool {
+	return cache.shard(key).Touch(key)
+}
+
+// ForEach runs a callback function for each cache item that has not expired
+//
+// in the callback, return true to continue, and false to break the loop
+func (cache *ShardedCache[K, V]) ForEach(cb func(key K, value V) bool, touch ...bool) {
+	for _, shard := range cache.shards {
+		next := true
+		shard.ForEach(func(key K, value V) bool {
+			next = cb(key, value)
+			return next
+		}, touch...)
+
+		if !next {
+			break
+		}
+	}
+}
+
+// Close removes all shards from the `CacheManager`, and removes the cache from the cache registry
+func (cache *ShardedCache[K, V]) Close() {
+	for _, shard := range cache.shards {
+		shard.Close()
+	}
+
+	if cache.name != "" {
+		unregisterCache(cache.name, cache)
+	}
+}
+
+// All returns an iterator over each cache item that has not expired
+func (cache *ShardedCache[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(key K, value V) bool) {
+		cache.ForEach(yield)
+	}
+}
+
+// Keys returns an iterator over the key of each cache item that has not expired
+func (cache *ShardedCache[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(key K) bool) {
+		cache.ForEach(func(key K, value V) bool {
+			return yield(key)
+		})
+	}
+}
+
+// Values returns an iterator over the value of each cache item that has not expired
+func (cache *ShardedCache[K, V]) Values() iter.Seq[V] {
+	return func(yield func(value V) bool) {
+		cache.ForEach(func(key K, value V) bool {
+			return yield(value)
+		})
+	}
+}
+
+// ForEachShard runs a callback function for each shard of the cache
+//
+// in the callback, return true to continue, and false to break the loop
+func (cache *ShardedCache[K, V]) ForEachShard(cb func(shard *CacheMap[K, V]) bool) {
+	for _, shard := range cache.shards {
+		if !cb(shard) {
+			break
+		}
+	}
+}
+
+// OnEvict adds a callback to run when an item is removed from the cache
+func (cache *ShardedCache[K, V]) OnEvict(cb func(key K, value V, reason EvictReason)) {
+	for _, shard := range cache.shards {
+		shard.OnEvict(cb)
+	}
+}
+
+// Stats returns the combined stats of all shards
+func (cache *ShardedCache[K, V]) Stats() CacheStats {
+	stats := CacheStats{Evictions: map[EvictReason]uint64{}}
+
+	var age time.Duration
+	for _, shard := range cache.shards {
+		s := shard.Stats()
+		stats.Hits += s.Hits
+		stats.Misses += s.Misses
+		stats.ErrHits += s.ErrHits
+		stats.Sets += s.Sets
+		stats.Size += s.Size
+		stats.Spilled += s.Spilled
+		age += s.AvgAge * time.Duration(s.Size)
+
+		for reason, count := range s.Evictions {
+			stats.Evictions[reason] += count
+		}
+	}
+
+	if stats.Size != 0 {
+		stats.AvgAge = age / time.Duration(stats.Size)
+	}
+
+	return stats
+}
+
+// shard returns the shard a key belongs to
+func (cache *ShardedCache[K, V]) shard(key K) *CacheMap[K, V] {
+	return cache.shards[hashKey(cache.seed, key)%uint64(len(cache.shards))]
+}
+
+// hashKey returns a hash of any Hashable key
+func hashKey[K Hashable](seed maphash.Seed, key K) uint64 {
+	switch k := any(key).(type) {
+	case string:
+		return maphash.String(seed, k)
+	case int:
+		return mixHash(uint64(k))
+	case int8:
+		return mixHash(uint64(k))
+	case int16:
+		return mixHash(uint64(k))
+	case int32:
+		return mixHash(uint64(k))
+	case int64:
+		return mixHash(uint64(k))
+	case uint:
+		return mixHash(uint64(k))
+	case uint8:
+		return mixHash(uint64(k))
+	case uint16:
+		return mixHash(uint64(k))
+	case uint32:
+		return mixHash(uint64(k))
+	case uint64:
+		return mixHash(k)
+	case uintptr:
+		return mixHash(uint64(k))
+	case float32:
+		return mixHash(uint64(float32Bits(k)))
+	case float64:
+		return mixHash(float64Bits(k))
+	case complex64:
+		return mixHash(uint64(float32Bits(real(k)))<<32 | uint64(float32Bits(imag(k))))
+	case complex128:
+		return mixHash(float64Bits(real(k)) ^ mixHash(float64Bits(imag(k))))
+	default:
+		return 0
+	}
+}
+
+// float32Bits returns the bits of a float, with -0 turned into +0,
+// since they are the same map key
+func float32Bits(f float32) uint32 {
+	if f == 0 {
+		f = 0
+	}
+	return math.Float32bits(f)
+}
+
+// float64Bits returns the bits of a float, with -0 turned into +0,
+// since they are the same map key
+func float64Bits(f float64) uint64 {
+	if f == 0 {
+		f = 0
+	}
+	return math.Float64bits(f)
+}
+
+// mixHash spreads the bits of a number, so sequential keys land on different shards
+//
+// this is the finalizer from splitmix64
+func mixHash(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
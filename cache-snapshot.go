@@ -0,0 +1,236 @@
+package goutil
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// CacheCodec encodes and decodes cache snapshots
+type CacheCodec interface {
+	Encode(w io.Writer, v any) error
+	Decode(r io.Reader, v any) error
+}
+
+type gobCodec struct{}
+type jsonCodec struct{}
+
+// GobCodec encodes cache snapshots with encoding/gob
+//
+// this is the default codec
+var GobCodec CacheCodec = &gobCodec{}
+
+// JsonCodec encodes cache snapshots with encoding/json
+var JsonCodec CacheCodec = &jsonCodec{}
+
+func (codec *gobCodec) Encode(w io.Writer, v any) error {
+	return gob.NewEncoder(w).Encode(v)
+}
+
+func (codec *gobCodec) Decode(r io.Reader, v any) error {
+	return gob.NewDecoder(r).Decode(v)
+}
+
+func (codec *jsonCodec) Encode(w io.Writer, v any) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (codec *jsonCodec) Decode(r io.Reader, v any) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+// cacheSnapshot is the encoded form of a cache
+type cacheSnapshot[K Hashable, V any] struct {
+	Items []cacheSnapshotItem[K, V]
+}
+
+// cacheSnapshotItem is the encoded form of a cache item
+//
+// errors are stored as strings, and restored with `errors.New`
+type cacheSnapshotItem[K Hashable, V any] struct {
+	Key     K
+	Value   V
+	Err     string
+	IsErr   bool
+	LastUse time.Time
+	Created time.Time
+	Updated time.Time
+	Freq    uint64
+	TTL     time.Duration
+	ExpAt   time.Time
+	Tags    []string
+}
+
+// Snapshot writes all of the cache items to a writer, so they can be loaded back with `Restore`
+//
+// values, errors (as strings), and last use times are kept,
+// so a restored cache keeps the same expiration state
+func (cache *CacheMap[K, V]) Snapshot(w io.Writer) error {
+	cache.mu.RLock()
+
+	snapshot := cacheSnapshot[K, V]{Items: make([]cacheSnapshotItem[K, V], 0, len(cache.entry))}
+
+	now := time.Now()
+	for key := range cache.entry {
+		if cache.expired(key, now) {
+			continue
+		}
+
+		snapshot.Items = append(snapshot.Items, cache.snapshotItem(key))
+	}
+
+	codec := cache.codec
+	cache.mu.RUnlock()
+
+	return codec.Encode(w, &snapshot)
+}
+
+// Restore loads cache items from a reader, that were written by `Snapshot`
+//
+// restored items replace any existing items with the same key,
+// and items that expired since the snapshot was taken are skipped
+func (cache *CacheMap[K, V]) Restore(r io.Reader) error {
+	cache.mu.RLock()
+	codec := cache.codec
+	cache.mu.RUnlock()
+
+	snapshot := cacheSnapshot[K, V]{}
+	if err := codec.Decode(r, &snapshot); err != nil {
+		return err
+	}
+
+	cache.mu.Lock()
+	defer cache.unlock()
+
+	now := time.Now()
+	for _, item := range snapshot.Items {
+		cache.restoreItem(item, now)
+	}
+
+	return nil
+}
+
+// snapshotItem returns the encoded form of a cache item
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) snapshotItem(key K) cacheSnapshotItem[K, V] {
+	item := cacheSnapshotItem[K, V]{
+		Key:     key,
+		Value:   cache.value[key],
+		Updated: cache.updated[key],
+		TTL:     cache.ttl[key],
+		ExpAt:   cache.expAt[key],
+		Tags:    cache.tags[key],
+	}
+
+	if entry, ok := cache.entry[key]; ok {
+		item.LastUse = time.Unix(0, entry.lastUse.Load())
+		item.Created = entry.created
+		item.Freq = entry.freq.Load()
+	}
+
+	if err, ok := cache.err[key]; ok {
+		item.Err = err.Error()
+		item.IsErr = true
+	}
+
+	return item
+}
+
+// restoreItem adds a cache item from its encoded form, keeping its timestamps
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) restoreItem(item cacheSnapshotItem[K, V], now time.Time) {
+	if item.IsErr {
+		cache.set(item.Key, cache.null, errors.New(item.Err))
+	} else {
+		cache.set(item.Key, item.Value, nil)
+	}
+
+	entry, ok := cache.entry[item.Key]
+	if !ok {
+		// evicted by the size limits
+		return
+	}
+
+	entry.lastUse.Store(item.LastUse.UnixNano())
+	entry.created = item.Created
+	entry.freq.Store(item.Freq)
+	cache.fixEntry(entry)
+	cache.updated[item.Key] = item.Updated
+	if item.TTL != 0 {
+		cache.ttl[item.Key] = item.TTL
+	}
+	if !item.ExpAt.IsZero() {
+		cache.expAt[item.Key] = item.ExpAt
+	}
+	cache.tag(item.Key, item.Tags...)
+
+	if cache.expired(item.Key, now) {
+		cache.delKey(item.Key, EVICT_EXPIRED)
+	}
+}
+
+// startAutoSave saves a snapshot of the cache to a file on an interval, until the cache is closed
+func (cache *CacheMap[K, V]) startAutoSave(path string, interval time.Duration) {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	cache.saveFile = path
+	cache.autoSave = stop
+	cache.autoSaved = done
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				cache.SnapshotFile(path)
+			}
+		}
+	}()
+}
+
+// SnapshotFile writes a `Snapshot` of the cache to a file
+//
+// the file is written to a temporary path first, and then renamed,
+// so an existing snapshot is never left half written
+func (cache *CacheMap[K, V]) SnapshotFile(path string) error {
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	if err := cache.Snapshot(file); err != nil {
+		file.Close()
+		os.Remove(path + ".tmp")
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// RestoreFile loads cache items from a file, that was written by `SnapshotFile`
+func (cache *CacheMap[K, V]) RestoreFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return cache.Restore(file)
+}
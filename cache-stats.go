@@ -0,0 +1,218 @@
+package goutil
+
+import (
+	"bufio"
+	"expvar"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats reports how well a cache is working
+type CacheStats struct {
+	// Hits is the number of lookups that found a value
+	Hits uint64
+
+	// Misses is the number of lookups that found nothing
+	Misses uint64
+
+	// ErrHits is the number of lookups that found a cached error
+	ErrHits uint64
+
+	// Sets is the number of values and errors that were added or replaced
+	Sets uint64
+
+	// Evictions is the number of items removed, by the reason they were removed
+	Evictions map[EvictReason]uint64
+
+	// Size is the current number of items (values and errors) in memory
+	Size int
+
+	// Spilled is the current number of items moved to disk (see `CacheOptions.SpillDir`)
+	Spilled int
+
+	// AvgAge is the average time since the current items were added
+	AvgAge time.Duration
+}
+
+// cacheCounters are the running totals behind `CacheStats`
+type cacheCounters struct {
+	// hits, misses, and errHits are atomic, so reads can count them while the cache is only read locked
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+	errHits atomic.Uint64
+
+	sets      uint64
+	evictions [evictReasons]uint64
+}
+
+// StatsReporter is implemented by caches that can be added to the cache registry
+type StatsReporter interface {
+	Stats() CacheStats
+}
+
+var cacheRegistry = map[string]StatsReporter{}
+var cacheRegistryMU sync.Mutex
+var cacheExpvarOnce sync.Once
+
+// MarshalText returns the name of the evict reason
+//
+// this lets `CacheStats.Evictions` be encoded as a json object with readable keys
+func (reason EvictReason) MarshalText() ([]byte, error) {
+	return []byte(reason.String()), nil
+}
+
+// Stats returns the hit, miss, and eviction counts of the cache, along with its current size
+func (cache *CacheMap[K, V]) Stats() CacheStats {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	stats := CacheStats{
+		Hits:      cache.stats.hits.Load(),
+		Misses:    cache.stats.misses.Load(),
+		ErrHits:   cache.stats.errHits.Load(),
+		Sets:      cache.stats.sets,
+		Evictions: map[EvictReason]uint64{},
+		Size:      len(cache.entry),
+	}
+
+	if cache.spill != nil {
+		stats.Spilled = len(cache.spill.files)
+	}
+
+	for reason, count := range cache.stats.evictions {
+		stats.Evictions[EvictReason(reason)] = count
+	}
+
+	if len(cache.entry) != 0 {
+		now := time.Now()
+		var age time.Duration
+		for _, entry := range cache.entry {
+			age += now.Sub(entry.created)
+		}
+		stats.AvgAge = age / time.Duration(len(cache.entry))
+	}
+
+	return stats
+}
+
+// RegisterCache adds a cache to the process wide cache registry by name
+//
+// registered caches can be listed with `CacheList`, and exported with
+// `PublishCacheExpvar` or `WriteCacheMetrics`
+//
+// registering a name that already exists will replace the old cache
+func RegisterCache(name string, cache StatsReporter) {
+	cacheRegistryMU.Lock()
+	defer cacheRegistryMU.Unlock()
+
+	cacheRegistry[name] = cache
+}
+
+// UnregisterCache removes a cache from the cache registry
+func UnregisterCache(name string) {
+	cacheRegistryMU.Lock()
+	defer cacheRegistryMU.Unlock()
+
+	delete(cacheRegistry, name)
+}
+
+// unregisterCache removes a cache from the cache registry,
+// only if the name has not been taken by another cache
+func unregisterCache(name string, cache StatsReporter) {
+	cacheRegistryMU.Lock()
+	defer cacheRegistryMU.Unlock()
+
+	if cacheRegistry[name] == cache {
+		delete(cacheRegistry, name)
+	}
+}
+
+// CacheList returns the stats of every registered cache by name
+func CacheList() map[string]CacheStats {
+	cacheRegistryMU.Lock()
+	caches := make(map[string]StatsReporter, len(cacheRegistry))
+	for name, cache := range cacheRegistry {
+		caches[name] = cache
+	}
+	cacheRegistryMU.Unlock()
+
+	list := make(map[string]CacheStats, len(caches))
+	for name, cache := range caches {
+		list[name] = cache.Stats()
+	}
+
+	return list
+}
+
+// PublishCacheExpvar publishes the stats of every registered cache
+// to expvar as "goutil_caches"
+//
+// it is safe to call this method more than once
+func PublishCacheExpvar() {
+	cacheExpvarOnce.Do(func() {
+		expvar.Publish("goutil_caches", expvar.Func(func() any {
+			return CacheList()
+		}))
+	})
+}
+
+// WriteCacheMetrics writes the stats of every registered cache
+// in the Prometheus text exposition format
+func WriteCacheMetrics(w io.Writer) error {
+	list := CacheList()
+
+	names := make([]string, 0, len(list))
+	for name := range list {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := bufio.NewWriter(w)
+
+	metric := func(name string, kind string, help string, value func(stats CacheStats) string) {
+		buf.WriteString("# HELP goutil_cache_" + name + " " + help + "\n")
+		buf.WriteString("# TYPE goutil_cache_" + name + " " + kind + "\n")
+		for _, cache := range names {
+			buf.WriteString("goutil_cache_" + name + `{cache="` + escapeMetricLabel(cache) + `"} ` + value(list[cache]) + "\n")
+		}
+	}
+
+	metric("hits_total", "counter", "Number of cache lookups that found a value.", func(stats CacheStats) string {
+		return strconv.FormatUint(stats.Hits, 10)
+	})
+	metric("misses_total", "counter", "Number of cache lookups that found nothing.", func(stats CacheStats) string {
+		return strconv.FormatUint(stats.Misses, 10)
+	})
+	metric("error_hits_total", "counter", "Number of cache lookups that found a cached error.", func(stats CacheStats) string {
+		return strconv.FormatUint(stats.ErrHits, 10)
+	})
+	metric("sets_total", "counter", "Number of cache items added or replaced.", func(stats CacheStats) string {
+		return strconv.FormatUint(stats.Sets, 10)
+	})
+	metric("size", "gauge", "Current number of cache items.", func(stats CacheStats) string {
+		return strconv.Itoa(stats.Size)
+	})
+	metric("avg_age_seconds", "gauge", "Average age of the current cache items.", func(stats CacheStats) string {
+		return strconv.FormatFloat(stats.AvgAge.Seconds(), 'g', -1, 64)
+	})
+
+	buf.WriteString("# HELP goutil_cache_evictions_total Number of cache items removed, by reason.\n")
+	buf.WriteString("# TYPE goutil_cache_evictions_total counter\n")
+	for _, cache := range names {
+		for reason := 0; reason < evictReasons; reason++ {
+			buf.WriteString(`goutil_cache_evictions_total{cache="` + escapeMetricLabel(cache) + `",reason="` + EvictReason(reason).String() + `"} ` + strconv.FormatUint(list[cache].Evictions[EvictReason(reason)], 10) + "\n")
+		}
+	}
+
+	return buf.Flush()
+}
+
+// escapeMetricLabel escapes a Prometheus label value
+func escapeMetricLabel(val string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(val)
+}
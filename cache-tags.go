@@ -0,0 +1,97 @@
+package goutil
+
+import "strings"
+
+// SetTagged sets or adds a new key with either a value, or an error,
+// and tags it so it can be removed with `InvalidateTag`
+//
+// tags are replaced the next time the key is set
+func (cache *CacheMap[K, V]) SetTagged(key K, value V, err error, tags ...string) {
+	cache.mu.Lock()
+	defer cache.unlock()
+
+	cache.set(key, value, err)
+	if _, ok := cache.entry[key]; ok {
+		cache.tag(key, tags...)
+	}
+}
+
+// InvalidateTag removes every cache item with a tag
+//
+// returns the number of items removed
+func (cache *CacheMap[K, V]) InvalidateTag(tag string) int {
+	cache.mu.Lock()
+	defer cache.unlock()
+
+	keys := make([]K, 0, len(cache.tagKeys[tag]))
+	for key := range cache.tagKeys[tag] {
+		keys = append(keys, key)
+	}
+
+	for _, key := range keys {
+		cache.delKey(key, EVICT_MANUAL)
+	}
+
+	return len(keys)
+}
+
+// DelPrefix removes every cache item with a string key that starts with a prefix
+//
+// this method does nothing for caches with non-string keys
+//
+// returns the number of items removed
+func (cache *CacheMap[K, V]) DelPrefix(prefix string) int {
+	cache.mu.Lock()
+	defer cache.unlock()
+
+	keys := []K{}
+	for key := range cache.entry {
+		if k, ok := any(key).(string); ok && strings.HasPrefix(k, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	if cache.spill != nil {
+		for key := range cache.spill.files {
+			if k, ok := any(key).(string); ok && strings.HasPrefix(k, prefix) {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	for _, key := range keys {
+		cache.delKey(key, EVICT_MANUAL)
+	}
+
+	return len(keys)
+}
+
+// tag adds tags to a key
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) tag(key K, tags ...string) {
+	for _, tag := range tags {
+		if _, ok := cache.tagKeys[tag][key]; ok {
+			continue
+		}
+
+		if cache.tagKeys[tag] == nil {
+			cache.tagKeys[tag] = map[K]struct{}{}
+		}
+		cache.tagKeys[tag][key] = struct{}{}
+		cache.tags[key] = append(cache.tags[key], tag)
+	}
+}
+
+// untag removes all tags from a key
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) untag(key K) {
+	for _, tag := range cache.tags[key] {
+		delete(cache.tagKeys[tag], key)
+		if len(cache.tagKeys[tag]) == 0 {
+			delete(cache.tagKeys, tag)
+		}
+	}
+	delete(cache.tags, key)
+}
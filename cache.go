@@ -1,183 +1,508 @@
 package goutil
 
 import (
+	"iter"
 	"sync"
 	"time"
 )
 
-var cacheListExpCB []func(cacheTime time.Duration)
-var cacheListDelCB []func()
-
 type CacheMap[K Hashable, V any] struct {
 	value   map[K]V
 	err     map[K]error
-	lastUse map[K]time.Time
+	entry   map[K]*cacheEntry[K]
+	queue   cacheQueue[K]
+	updated map[K]time.Time
+	cost    map[K]int64
+	ttl     map[K]time.Duration
+	expAt   map[K]time.Time
+	tags    map[K][]string
+	tagKeys map[string]map[K]struct{}
 	exp     time.Duration
-	mu      sync.Mutex
+	errExp  time.Duration
+	mu      sync.RWMutex
 	null    V
+
+	loading      map[K]*cacheLoad[V]
+	loader       func(key K) (V, error)
+	softExp      time.Duration
+	hardExp      time.Duration
+	onRefreshErr func(key K, err error)
+	marshalMeta  bool
+
+	evictCB []func(key K, value V, reason EvictReason)
+	evicted []cacheEvicted[K, V]
+
+	codec    CacheCodec
+	saveFile string
+
+	// autoSave stops the snapshot goroutine, and autoSaved is closed once it has stopped
+	autoSave  chan struct{}
+	autoSaved chan struct{}
+	name      string
+	spill     *cacheSpill[K]
+
+	stats cacheCounters
+
+	maxSize   int
+	maxCost   int64
+	totalCost int64
+	sizer     func(key K, value V) int64
+	policy    CachePolicy
 }
 
-func init() {
-	go func() {
-		for {
-			time.Sleep(10 * time.Minute)
-
-			var cacheTime time.Duration
-
-			// SysFreeMemory returns the total free system memory in megabytes
-			mb := SysFreeMemory()
-			if mb < 200 && mb != 0 {
-				// low memory: remove cache items have not been accessed in over 10 minutes
-				cacheTime = 10 * time.Minute
-			} else if mb < 500 && mb != 0 {
-				// low memory: remove cache items have not been accessed in over 30 minutes
-				cacheTime = 30 * time.Minute
-			} else if mb < 2000 && mb != 0 {
-				// low memory: remove cache items have not been accessed in over 1 hour
-				cacheTime = 1 * time.Hour
-			} else if mb > 64000 {
-				// high memory: remove cache items have not been accessed in over 12 hour
-				cacheTime = 12 * time.Hour
-			} else if mb > 32000 {
-				// high memory: remove cache items have not been accessed in over 6 hour
-				cacheTime = 6 * time.Hour
-			} else if mb > 16000 {
-				// high memory: remove cache items have not been accessed in over 3 hour
-				cacheTime = 3 * time.Hour
-			}
+// CachePolicy decides which items get evicted when a cache reaches its size limit
+type CachePolicy uint8
 
-			for _, cb := range cacheListExpCB {
-				cb(cacheTime)
-			}
+const (
+	// CACHE_LRU evicts the least recently used item
+	CACHE_LRU CachePolicy = iota
 
-			time.Sleep(10 * time.Second)
+	// CACHE_LFU evicts the least frequently used item
+	CACHE_LFU
 
-			if mb := SysFreeMemory(); mb < 10 && mb != 0 {
-				for _, cb := range cacheListDelCB {
-					cb()
-				}
-			}
-		}
-	}()
+	// CACHE_FIFO evicts the oldest item
+	CACHE_FIFO
+)
+
+// EvictReason is the reason a cache item was removed
+type EvictReason uint8
+
+const (
+	// EVICT_EXPIRED means the item expired or was not accessed for too long
+	EVICT_EXPIRED EvictReason = iota
+
+	// EVICT_CAPACITY means the item was removed to keep the cache within its size limits
+	EVICT_CAPACITY
+
+	// EVICT_MANUAL means the item was removed with the `Del` method
+	EVICT_MANUAL
+
+	// EVICT_LOW_MEMORY means the item was purged because the system was low on memory
+	EVICT_LOW_MEMORY
+
+	// EVICT_REPLACED means the item was overwritten by a new value or error
+	EVICT_REPLACED
+)
+
+// evictReasons is the number of EvictReason values
+const evictReasons = int(EVICT_REPLACED) + 1
+
+// String returns the name of the evict reason
+func (reason EvictReason) String() string {
+	switch reason {
+	case EVICT_EXPIRED:
+		return "expired"
+	case EVICT_CAPACITY:
+		return "capacity"
+	case EVICT_MANUAL:
+		return "manual"
+	case EVICT_LOW_MEMORY:
+		return "low_memory"
+	case EVICT_REPLACED:
+		return "replaced"
+	default:
+		return "unknown"
+	}
+}
+
+// cacheEvicted is a removed cache item, waiting for the OnEvict callbacks to run
+type cacheEvicted[K Hashable, V any] struct {
+	key    K
+	value  V
+	reason EvictReason
+}
+
+// CacheOptions are optional settings for the `NewCache` method
+type CacheOptions[K Hashable, V any] struct {
+	// MaxSize is the max number of items (values and errors) the cache can hold
+	//
+	// default: 0 (unlimited)
+	MaxSize int
+
+	// MaxCost is the max total cost of all items the cache can hold
+	//
+	// note: this requires a Sizer
+	//
+	// default: 0 (unlimited)
+	MaxCost int64
+
+	// Sizer returns the cost of a cache item (for example, its size in bytes)
+	//
+	// for error items, value is the nil/zero value of its type
+	Sizer func(key K, value V) int64
+
+	// Policy decides which items get evicted when MaxSize or MaxCost is reached
+	//
+	// default: CACHE_LRU
+	Policy CachePolicy
+
+	// ErrExp removes cached errors after this duration, even if they are still being accessed
+	//
+	// this lets a failed lookup be retried sooner than a successful one would expire
+	//
+	// default: 0 (errors follow the same expiration as values)
+	ErrExp time.Duration
+
+	// Codec encodes and decodes cache snapshots
+	//
+	// default: GobCodec
+	Codec CacheCodec
+
+	// SnapshotFile is a file path to restore the cache from when it is created,
+	// and to save snapshots to every SnapshotInterval
+	//
+	// default: "" (disabled)
+	SnapshotFile string
+
+	// SnapshotInterval is how often the cache is saved to the SnapshotFile
+	//
+	// default: 0 (the cache is only restored, and not saved automatically)
+	SnapshotInterval time.Duration
+
+	// Name registers the cache with `RegisterCache`, so its stats can be listed and exported
+	//
+	// default: "" (not registered)
+	Name string
+
+	// NoSweep opts the cache out of the `CacheManager`,
+	// so its items are never removed when the system is low on memory
+	//
+	// default: false
+	NoSweep bool
+
+	// SpillDir is a directory to move cold items to, instead of removing them,
+	// when the `CacheManager` finds the system is low on memory
+	//
+	// spilled items are encoded with the Codec, and loaded back into memory by `Get` and `Has`
+	// (they are skipped by `ForEach` and `Snapshot`)
+	//
+	// each cache spills into its own subdirectory, so several caches can share a SpillDir,
+	// and the subdirectory is removed when the cache is closed
+	//
+	// note: OnEvict callbacks do not run for items that are removed while they are on disk
+	//
+	// default: "" (disabled)
+	SpillDir string
+
+	// SpillQuota is the max number of bytes the SpillDir can use,
+	// before the least recently used files are removed
+	//
+	// default: 0 (unlimited)
+	SpillQuota int64
+
+	// Loader loads missing items for `Get` and `Has`, and refreshes stale items in the background
+	//
+	// with a Loader, `Get` works like `GetOrLoad`
+	//
+	// default: nil
+	Loader func(key K) (V, error)
+
+	// SoftExp is how long after an item is set, that it becomes stale
+	//
+	// stale items are still returned right away, while a loader refreshes them in the background
+	// (the Loader, or the loader passed to `GetOrLoad`)
+	//
+	// if a refresh fails, the stale item is kept
+	//
+	// default: 0 (items never become stale)
+	SoftExp time.Duration
+
+	// HardExp is how long after an item is set, that it expires, no matter how often it is accessed
+	//
+	// once expired, `Get` will wait for the Loader, or return nothing if there is no Loader
+	//
+	// default: 0 (items follow the normal cache expiration)
+	HardExp time.Duration
+
+	// OnRefreshError runs when a background refresh of a stale item fails
+	//
+	// default: nil
+	OnRefreshError func(key K, err error)
+
+	// MarshalMeta includes the errors and expiration state of each item,
+	// when the cache is encoded to json or yaml
+	//
+	// default: false (only values are encoded)
+	MarshalMeta bool
+}
+
+// cacheLoad is an in-flight `GetOrLoad` call, that other callers can wait on
+type cacheLoad[V any] struct {
+	wg    sync.WaitGroup
+	value V
+	err   error
 }
 
 // NewCache creates a new cache map
-func NewCache[K Hashable, V any](exp time.Duration) *CacheMap[K, V] {
-	cache := CacheMap[K, V]{
-		value:   map[K]V{},
-		err:     map[K]error{},
-		lastUse: map[K]time.Time{},
-		exp:     exp,
-	}
+//
+// @exp: remove items that have not been accessed within this duration (0 = never)
+//
+// @opts: optional settings for size limits and eviction policies
+func NewCache[K Hashable, V any](exp time.Duration, opts ...CacheOptions[K, V]) *CacheMap[K, V] {
+	cache := CacheMap[K, V]{exp: exp}
+	cache.initMaps()
+
+	if len(opts) != 0 {
+		cache.maxSize = opts[0].MaxSize
+		cache.maxCost = opts[0].MaxCost
+		cache.sizer = opts[0].Sizer
+		cache.policy = opts[0].Policy
+		cache.queue.policy = opts[0].Policy
+		cache.errExp = opts[0].ErrExp
+		cache.loader = opts[0].Loader
+		cache.softExp = opts[0].SoftExp
+		cache.hardExp = opts[0].HardExp
+		cache.onRefreshErr = opts[0].OnRefreshError
+		cache.marshalMeta = opts[0].MarshalMeta
+
+		if opts[0].Codec != nil {
+			cache.codec = opts[0].Codec
+		}
+
+		if path := opts[0].SnapshotFile; path != "" {
+			cache.RestoreFile(path)
 
-	cacheListExpCB = append(cacheListExpCB, func(cacheTime time.Duration) {
-		if cacheTime == 0 || (cacheTime < cache.exp && cacheTime >= 3*time.Hour) {
-			cacheTime = cache.exp
+			if opts[0].SnapshotInterval != 0 {
+				cache.startAutoSave(path, opts[0].SnapshotInterval)
+			}
 		}
 
-		if cacheTime == 0 {
-			return
+		if opts[0].SpillDir != "" {
+			cache.initSpill(opts[0].SpillDir, opts[0].SpillQuota)
 		}
 
-		cache.DelOld(cacheTime)
-	})
+		if opts[0].Name != "" {
+			cache.name = opts[0].Name
+			RegisterCache(opts[0].Name, &cache)
+		}
+	}
 
-	cacheListDelCB = append(cacheListDelCB, func() {
-		cache.DelOld(0)
-	})
+	if len(opts) == 0 || !opts[0].NoSweep {
+		CacheManager.Register(&cache)
+	}
 
 	return &cache
 }
 
+// initMaps creates the maps of a new cache
+func (cache *CacheMap[K, V]) initMaps() {
+	cache.value = map[K]V{}
+	cache.err = map[K]error{}
+	cache.entry = map[K]*cacheEntry[K]{}
+	cache.queue = cacheQueue[K]{policy: cache.policy}
+	cache.updated = map[K]time.Time{}
+	cache.cost = map[K]int64{}
+	cache.ttl = map[K]time.Duration{}
+	cache.expAt = map[K]time.Time{}
+	cache.tags = map[K][]string{}
+	cache.tagKeys = map[string]map[K]struct{}{}
+	cache.loading = map[K]*cacheLoad[V]{}
+
+	if cache.codec == nil {
+		cache.codec = GobCodec
+	}
+}
+
+// Close removes the cache from the `CacheManager` and the cache registry,
+// stops saving snapshots (after saving one last time), and removes any items that were moved to the SpillDir
+//
+// the cache can still be used after it is closed,
+// but its items will no longer be removed when the system is low on memory
+func (cache *CacheMap[K, V]) Close() {
+	CacheManager.Unregister(cache)
+
+	cache.mu.Lock()
+	name := cache.name
+	autoSave, autoSaved := cache.autoSave, cache.autoSaved
+	saveFile := cache.saveFile
+	cache.name = ""
+	cache.autoSave, cache.autoSaved = nil, nil
+	cache.unlock()
+
+	if name != "" {
+		unregisterCache(name, cache)
+	}
+
+	if autoSave != nil {
+		// wait for any snapshot in progress, so it does not write over the last one
+		close(autoSave)
+		<-autoSaved
+		cache.SnapshotFile(saveFile)
+	}
+
+	cache.mu.Lock()
+	cache.closeSpill()
+	cache.unlock()
+}
+
 // Get returns a value or an error if it exists
 //
 // if the object key does not exist, it will return both a nil/zero value (of the relevant type) and nil error
+//
+// if the cache has a Loader, missing items will be loaded (see `GetOrLoad`)
 func (cache *CacheMap[K, V]) Get(key K) (V, error) {
+	if cache.loader != nil {
+		return cache.GetOrLoad(key, cache.loader)
+	}
+
+	cache.mu.RLock()
+	val, err, _, ok := cache.peek(key, time.Now(), false)
+	cache.mu.RUnlock()
+
+	if ok {
+		return val, err
+	}
+
 	cache.mu.Lock()
-	defer cache.mu.Unlock()
+	defer cache.unlock()
 
-	if err, ok := cache.err[key]; ok {
-		cache.lastUse[key] = time.Now()
-		return cache.null, err
-	} else if val, ok := cache.value[key]; ok {
-		cache.lastUse[key] = time.Now()
-		return val, nil
+	val, err, _ = cache.get(key)
+	return val, err
+}
+
+// GetOrLoad returns a value or an error if it exists,
+// or runs the loader and caches its result if it does not
+//
+// only one loader runs for a key at a time, and any other callers
+// requesting the same key will wait for that loader to finish
+//
+// if the item is stale (see `CacheOptions.SoftExp`), it is returned right away,
+// and refreshed by the loader in the background
+func (cache *CacheMap[K, V]) GetOrLoad(key K, loader func(key K) (V, error)) (V, error) {
+	cache.mu.RLock()
+	val, err, _, ok := cache.peek(key, time.Now(), true)
+	cache.mu.RUnlock()
+
+	if ok {
+		return val, err
 	}
 
-	return cache.null, nil
+	cache.mu.Lock()
+
+	if val, err, ok := cache.get(key); ok {
+		if cache.stale(key, time.Now()) {
+			cache.refresh(key, loader)
+		}
+
+		cache.unlock()
+		return val, err
+	}
+
+	if load, ok := cache.loading[key]; ok {
+		cache.unlock()
+		load.wg.Wait()
+		return load.value, load.err
+	}
+
+	load := &cacheLoad[V]{}
+	load.wg.Add(1)
+	cache.loading[key] = load
+	cache.unlock()
+
+	defer func() {
+		cache.mu.Lock()
+		delete(cache.loading, key)
+		cache.unlock()
+		load.wg.Done()
+	}()
+
+	load.value, load.err = loader(key)
+	cache.Set(key, load.value, load.err)
+
+	return load.value, load.err
 }
 
 // Set sets or adds a new key with either a value, or an error
 func (cache *CacheMap[K, V]) Set(key K, value V, err error) {
 	cache.mu.Lock()
-	defer cache.mu.Unlock()
+	defer cache.unlock()
 
-	if err != nil {
-		cache.err[key] = err
-		delete(cache.value, key)
-		cache.lastUse[key] = time.Now()
-	} else {
-		cache.value[key] = value
-		delete(cache.err, key)
-		cache.lastUse[key] = time.Now()
+	cache.set(key, value, err)
+}
+
+// SetWithTTL sets or adds a new key with a value, that expires
+// if it has not been accessed within the ttl
+//
+// this overrides the expiration of the cache for this item
+func (cache *CacheMap[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	cache.mu.Lock()
+	defer cache.unlock()
+
+	cache.set(key, value, nil)
+	if _, ok := cache.entry[key]; ok {
+		cache.ttl[key] = ttl
+	}
+}
+
+// SetWithDeadline sets or adds a new key with a value, that expires
+// at a fixed time, no matter how often it is accessed
+func (cache *CacheMap[K, V]) SetWithDeadline(key K, value V, deadline time.Time) {
+	cache.mu.Lock()
+	defer cache.unlock()
+
+	cache.set(key, value, nil)
+	if _, ok := cache.entry[key]; ok {
+		cache.expAt[key] = deadline
 	}
 }
 
 // Del removes a cache item by key
 func (cache *CacheMap[K, V]) Del(key K) {
 	cache.mu.Lock()
-	defer cache.mu.Unlock()
+	defer cache.unlock()
 
-	delete(cache.value, key)
-	delete(cache.err, key)
-	delete(cache.lastUse, key)
+	cache.delKey(key, EVICT_MANUAL)
 }
 
 // DelOld removes old cache items
+//
+// @cacheTime: remove items that have not been accessed within this duration (0 = remove all items)
 func (cache *CacheMap[K, V]) DelOld(cacheTime time.Duration) {
-	cache.mu.Lock()
-	defer cache.mu.Unlock()
-
 	if cacheTime == 0 {
-		for key := range cache.lastUse {
-			delete(cache.value, key)
-			delete(cache.err, key)
-			delete(cache.lastUse, key)
-		}
-		return
+		cache.delOld(0, EVICT_MANUAL)
+	} else {
+		cache.delOld(cacheTime, EVICT_EXPIRED)
 	}
+}
 
-	now := time.Now().UnixNano()
+// OnEvict adds a callback to run when an item is removed from the cache
+//
+// callbacks run after the cache is unlocked, so they may safely use the cache
+func (cache *CacheMap[K, V]) OnEvict(cb func(key K, value V, reason EvictReason)) {
+	cache.mu.Lock()
+	defer cache.unlock()
 
-	for key, lastUse := range cache.lastUse {
-		if now-lastUse.UnixNano() > int64(cacheTime) {
-			delete(cache.value, key)
-			delete(cache.err, key)
-			delete(cache.lastUse, key)
-		}
-	}
+	cache.evictCB = append(cache.evictCB, cb)
 }
 
 // Has returns true if a key value exists and is not an error
+//
+// if the cache has a Loader, missing items will be loaded (see `GetOrLoad`)
 func (cache *CacheMap[K, V]) Has(key K) bool {
-	cache.mu.Lock()
-	defer cache.mu.Unlock()
+	if cache.loader != nil {
+		_, err := cache.GetOrLoad(key, cache.loader)
+		return err == nil
+	}
 
-	if _, ok := cache.err[key]; ok {
-		cache.lastUse[key] = time.Now()
-		return false
-	} else if _, ok := cache.value[key]; ok {
-		cache.lastUse[key] = time.Now()
-		return true
+	cache.mu.RLock()
+	_, err, found, ok := cache.peek(key, time.Now(), false)
+	cache.mu.RUnlock()
+
+	if ok {
+		return found && err == nil
 	}
 
-	return false
+	cache.mu.Lock()
+	defer cache.unlock()
+
+	_, err, found = cache.get(key)
+	return found && err == nil
 }
 
 // Expire sets the ttl for all cache items
 func (cache *CacheMap[K, V]) Expire(exp time.Duration) bool {
 	cache.mu.Lock()
-	defer cache.mu.Unlock()
+	defer cache.unlock()
 
 	cache.exp = exp
 
@@ -185,52 +510,445 @@ func (cache *CacheMap[K, V]) Expire(exp time.Duration) bool {
 }
 
 // Touch resets a cache items expiration so it will stay in the cache longer
+//
+// returns false if the key does not exist
 func (cache *CacheMap[K, V]) Touch(key K) bool {
-	cache.mu.Lock()
-	defer cache.mu.Unlock()
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
 
-	cache.lastUse[key] = time.Now()
+	now := time.Now()
 
-	return false
+	entry, ok := cache.entry[key]
+	if !ok || cache.expired(key, now) {
+		return false
+	}
+
+	entry.lastUse.Store(now.UnixNano())
+	return true
 }
 
 // ForEach runs a callback function for each cache item that has not expired
 //
 // in the callback, return true to continue, and false to break the loop
 func (cache *CacheMap[K, V]) ForEach(cb func(key K, value V) bool, touch ...bool) {
-	cache.mu.Lock()
-	keyList := []K{}
+	cache.mu.RLock()
+	keyList := make([]K, 0, len(cache.value))
 	for key := range cache.value {
 		keyList = append(keyList, key)
 	}
-	cache.mu.Unlock()
+	cache.mu.RUnlock()
 
 	now := time.Now()
 	for _, key := range keyList {
 		cache.mu.Lock()
 		if _, ok := cache.err[key]; ok {
-			cache.lastUse[key] = now
-			cache.mu.Unlock()
+			if entry, ok := cache.entry[key]; ok {
+				entry.lastUse.Store(now.UnixNano())
+			}
+			cache.unlock()
 			continue
 		}
 
-		if now.UnixNano()-cache.lastUse[key].UnixNano() > int64(cache.exp) {
-			delete(cache.value, key)
-			delete(cache.err, key)
-			delete(cache.lastUse, key)
-			cache.mu.Unlock()
+		if cache.expired(key, now) {
+			cache.delKey(key, EVICT_EXPIRED)
+			cache.unlock()
 			continue
 		}
 
-		var val V
-		if v, ok := cache.value[key]; ok {
-			val = v
+		val, ok := cache.value[key]
+		if !ok {
+			// removed since the key list was made
+			cache.unlock()
+			continue
 		}
 
-		cache.mu.Unlock()
+		cache.unlock()
 
 		if !cb(key, val) {
 			break
 		}
 	}
 }
+
+// All returns an iterator over each cache item that has not expired
+//
+// like `ForEach`, the cache is not locked while the loop body runs
+func (cache *CacheMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(key K, value V) bool) {
+		cache.ForEach(yield)
+	}
+}
+
+// Keys returns an iterator over the key of each cache item that has not expired
+func (cache *CacheMap[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(key K) bool) {
+		cache.ForEach(func(key K, value V) bool {
+			return yield(key)
+		})
+	}
+}
+
+// Values returns an iterator over the value of each cache item that has not expired
+func (cache *CacheMap[K, V]) Values() iter.Seq[V] {
+	return func(yield func(value V) bool) {
+		cache.ForEach(func(key K, value V) bool {
+			return yield(value)
+		})
+	}
+}
+
+// get returns a value or an error, and true if the key exists
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) get(key K) (V, error, bool) {
+	now := time.Now()
+
+	if cache.spill != nil {
+		if _, ok := cache.entry[key]; !ok {
+			cache.loadSpilled(key, now)
+		}
+	}
+
+	if cache.expired(key, now) {
+		cache.delKey(key, EVICT_EXPIRED)
+		cache.stats.misses.Add(1)
+		return cache.null, nil, false
+	}
+
+	if err, ok := cache.err[key]; ok {
+		cache.useEntry(cache.entry[key], now)
+		cache.stats.errHits.Add(1)
+		return cache.null, err, true
+	} else if val, ok := cache.value[key]; ok {
+		cache.useEntry(cache.entry[key], now)
+		cache.stats.hits.Add(1)
+		return val, nil, true
+	}
+
+	cache.stats.misses.Add(1)
+	return cache.null, nil, false
+}
+
+// peek returns a value or an error, while the cache is only read locked
+//
+// @load: leave missing and stale items to the caller, so they can be loaded
+//
+// @found: true if the key exists
+//
+// @ok: false if the item needs the write lock (to be removed, loaded from disk, or loaded by the caller)
+//
+// note: the cache must already be read locked
+func (cache *CacheMap[K, V]) peek(key K, now time.Time, load bool) (val V, err error, found bool, ok bool) {
+	entry, exists := cache.entry[key]
+	if !exists {
+		if load || cache.spill != nil {
+			return cache.null, nil, false, false
+		}
+
+		cache.stats.misses.Add(1)
+		return cache.null, nil, false, true
+	}
+
+	if cache.expired(key, now) || (load && cache.stale(key, now)) {
+		return cache.null, nil, false, false
+	}
+
+	entry.use(now)
+
+	if err, isErr := cache.err[key]; isErr {
+		cache.stats.errHits.Add(1)
+		return cache.null, err, true, true
+	}
+
+	cache.stats.hits.Add(1)
+	return cache.value[key], nil, true, true
+}
+
+// set sets or adds a new key with either a value, or an error
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) set(key K, value V, err error) {
+	now := time.Now()
+
+	entry, exists := cache.entry[key]
+	if exists {
+		cache.stats.evictions[EVICT_REPLACED]++
+		if len(cache.evictCB) != 0 {
+			cache.evicted = append(cache.evicted, cacheEvicted[K, V]{key: key, value: cache.value[key], reason: EVICT_REPLACED})
+		}
+	}
+	cache.stats.sets++
+
+	if cache.spill != nil {
+		cache.unspill(key)
+	}
+	cache.untag(key)
+
+	if err != nil {
+		cache.err[key] = err
+		delete(cache.value, key)
+	} else {
+		cache.value[key] = value
+		delete(cache.err, key)
+	}
+
+	if !exists {
+		entry = cache.addEntry(key, now)
+	}
+	cache.useEntry(entry, now)
+	cache.updated[key] = now
+	delete(cache.ttl, key)
+	delete(cache.expAt, key)
+
+	if cache.sizer != nil {
+		var c int64
+		if err != nil {
+			c = cache.sizer(key, cache.null)
+		} else {
+			c = cache.sizer(key, value)
+		}
+		cache.totalCost += c - cache.cost[key]
+		cache.cost[key] = c
+	}
+
+	cache.evict(key)
+}
+
+// expired returns true if a cache item should no longer be returned
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) expired(key K, now time.Time) bool {
+	if expAt, ok := cache.expAt[key]; ok && now.After(expAt) {
+		return true
+	}
+
+	exp := cache.exp
+	if ttl, ok := cache.ttl[key]; ok {
+		exp = ttl
+	}
+
+	if exp != 0 && now.Sub(cache.lastUse(key)) > exp {
+		return true
+	}
+
+	if cache.errExp != 0 {
+		if _, ok := cache.err[key]; ok && now.Sub(cache.updated[key]) > cache.errExp {
+			return true
+		}
+	}
+
+	if cache.hardExp != 0 && now.Sub(cache.updated[key]) > cache.hardExp {
+		return true
+	}
+
+	return false
+}
+
+// stale returns true if a cache item should be refreshed in the background
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) stale(key K, now time.Time) bool {
+	if cache.softExp == 0 {
+		return false
+	}
+
+	if _, ok := cache.loading[key]; ok {
+		return false
+	}
+
+	updated, ok := cache.updated[key]
+	return ok && now.Sub(updated) > cache.softExp
+}
+
+// refresh runs a loader in the background, and replaces a stale item with its result
+//
+// if the loader fails, the stale item is kept, and OnRefreshError is called
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) refresh(key K, loader func(key K) (V, error)) {
+	load := &cacheLoad[V]{}
+	load.wg.Add(1)
+	cache.loading[key] = load
+
+	go func() {
+		defer func() {
+			cache.mu.Lock()
+			delete(cache.loading, key)
+			cache.unlock()
+			load.wg.Done()
+		}()
+
+		load.value, load.err = loader(key)
+
+		if load.err != nil {
+			if cache.onRefreshErr != nil {
+				cache.onRefreshErr(key, load.err)
+			}
+			return
+		}
+
+		cache.mu.Lock()
+		if _, ok := cache.entry[key]; ok {
+			// keep the settings of the stale item
+			lastUse := cache.lastUse(key)
+			ttl, hasTTL := cache.ttl[key]
+			expAt, hasExpAt := cache.expAt[key]
+			tags := cache.tags[key]
+
+			cache.set(key, load.value, nil)
+
+			if entry, ok := cache.entry[key]; ok {
+				entry.lastUse.Store(lastUse.UnixNano())
+				cache.fixEntry(entry)
+				if hasTTL {
+					cache.ttl[key] = ttl
+				}
+				if hasExpAt {
+					cache.expAt[key] = expAt
+				}
+				cache.tag(key, tags...)
+			}
+		}
+		cache.unlock()
+	}()
+}
+
+// delOld removes old cache items for an evict reason
+func (cache *CacheMap[K, V]) delOld(cacheTime time.Duration, reason EvictReason) {
+	cache.mu.Lock()
+	defer cache.unlock()
+
+	if cacheTime == 0 {
+		for key := range cache.entry {
+			cache.delKey(key, reason)
+		}
+
+		if cache.spill != nil && reason != EVICT_LOW_MEMORY {
+			cache.clearSpill()
+		}
+		return
+	}
+
+	now := time.Now()
+
+	for key, entry := range cache.entry {
+		if cache.expired(key, now) {
+			cache.delKey(key, EVICT_EXPIRED)
+		} else if now.UnixNano()-entry.lastUse.Load() > int64(cacheTime) {
+			cache.delKey(key, reason)
+		}
+	}
+
+	if cache.spill != nil && reason != EVICT_LOW_MEMORY {
+		cache.sweepSpilled(cacheTime, now)
+	}
+}
+
+// sweep removes old cache items for the `CacheManager`
+//
+// @cacheTime: the ttl from the CacheManager thresholds (0 = no threshold matched)
+//
+// @lowMemory: true if cacheTime comes from a low memory threshold, and may be shorter than the cache ttl
+func (cache *CacheMap[K, V]) sweep(cacheTime time.Duration, lowMemory bool) {
+	cache.mu.RLock()
+	exp := cache.exp
+	cache.mu.RUnlock()
+
+	if cacheTime == 0 || (!lowMemory && cacheTime < exp) {
+		cacheTime = exp
+		lowMemory = false
+	}
+
+	if cacheTime == 0 {
+		return
+	}
+
+	if lowMemory {
+		cache.delOld(cacheTime, EVICT_LOW_MEMORY)
+	} else {
+		cache.delOld(cacheTime, EVICT_EXPIRED)
+	}
+}
+
+// purge removes all cache items for the `CacheManager`, when the system is almost out of memory
+func (cache *CacheMap[K, V]) purge() {
+	cache.delOld(0, EVICT_LOW_MEMORY)
+}
+
+// unlock unlocks the cache, and then runs the OnEvict callbacks
+// for any items that were removed while it was locked
+func (cache *CacheMap[K, V]) unlock() {
+	evicted := cache.evicted
+	cache.evicted = nil
+	evictCB := cache.evictCB
+	cache.mu.Unlock()
+
+	for _, item := range evicted {
+		for _, cb := range evictCB {
+			cb(item.key, item.value, item.reason)
+		}
+	}
+}
+
+// delKey removes a key from all of the cache maps
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) delKey(key K, reason EvictReason) {
+	if cache.spill != nil {
+		if _, ok := cache.entry[key]; ok && reason == EVICT_LOW_MEMORY && cache.spillKey(key) == nil {
+			cache.clearKey(key)
+			return
+		}
+
+		cache.unspill(key)
+	}
+
+	if _, ok := cache.entry[key]; ok {
+		cache.stats.evictions[reason]++
+		if len(cache.evictCB) != 0 {
+			cache.evicted = append(cache.evicted, cacheEvicted[K, V]{key: key, value: cache.value[key], reason: reason})
+		}
+	}
+
+	cache.clearKey(key)
+	cache.untag(key)
+}
+
+// clearKey removes a key from all of the in memory cache maps, without running any callbacks
+//
+// tags are kept, so items moved to disk can still be found by `InvalidateTag`
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) clearKey(key K) {
+	delete(cache.value, key)
+	delete(cache.err, key)
+	cache.removeEntry(key)
+	delete(cache.updated, key)
+	delete(cache.ttl, key)
+	delete(cache.expAt, key)
+
+	if c, ok := cache.cost[key]; ok {
+		cache.totalCost -= c
+		delete(cache.cost, key)
+	}
+}
+
+// evict removes items by the cache policy, until the cache is within its size limits
+//
+// @keep: a key that should not be evicted (usually the one that was just added)
+//
+// note: the cache must already be locked
+func (cache *CacheMap[K, V]) evict(keep K) {
+	for (cache.maxSize > 0 && len(cache.entry) > cache.maxSize) || (cache.maxCost > 0 && cache.totalCost > cache.maxCost) {
+		victim := cache.queue.next(keep)
+		if victim == nil {
+			// only the kept item is left, and it is too large on its own
+			if cache.maxCost > 0 && cache.totalCost > cache.maxCost {
+				cache.delKey(keep, EVICT_CAPACITY)
+			}
+			return
+		}
+
+		cache.delKey(victim.key, EVICT_CAPACITY)
+	}
+}
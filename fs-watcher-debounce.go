@@ -0,0 +1,424 @@
+package goutil
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fsMaxDebounce limits how many debounce windows a busy batch can be delayed by
+const fsMaxDebounce = 10
+
+// FSEvent is a file system change, after its raw events have been coalesced
+type FSEvent struct {
+	// the file path the change happened to
+	Path string
+
+	// the kind of change (FSEVENT_ADD, FSEVENT_MODIFY, FSEVENT_REMOVE, or FSEVENT_MOVE)
+	Kind FSEventKind
+
+	// the change operations that were coalesced into this event (example: FSOP_CREATE|FSOP_WRITE)
+	Op FSOp
+
+	IsDir bool
+
+	// the previous file path, for FSEVENT_MOVE events
+	OldPath string
+
+	// the sha256 of the file content, when `FSWatcher.HashContent` is enabled
+	Hash string
+
+	// when the first change to the path was seen
+	Time time.Time
+
+	// the directory that was passed to `WatchDir`, or the file that was passed to `WatchFile`
+	Root string
+}
+
+// fsInode identifies a file by its device and inode, so it can be found again after it is renamed
+type fsInode struct {
+	dev uint64
+	ino uint64
+}
+
+// fileInode returns the device and inode of a file
+func fileInode(info os.FileInfo) (fsInode, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fsInode{}, false
+	}
+	return fsInode{dev: uint64(stat.Dev), ino: stat.Ino}, true
+}
+
+// fsPending is a path with raw events that are waiting for the debounce window to end
+type fsPending struct {
+	path  string
+	first fsnotify.Op
+	op    fsnotify.Op
+	time  time.Time
+}
+
+// fsBatch coalesces raw events by path, in the order each path was first seen
+type fsBatch struct {
+	list  []*fsPending
+	index map[string]*fsPending
+	start time.Time
+}
+
+// add adds a raw event to the batch
+func (batch *fsBatch) add(event fsnotify.Event) {
+	if pending, ok := batch.index[event.Name]; ok {
+		pending.op |= event.Op
+		return
+	}
+
+	now := time.Now()
+	if len(batch.list) == 0 {
+		batch.start = now
+	}
+
+	pending := &fsPending{path: event.Name, first: event.Op, op: event.Op, time: now}
+	batch.index[event.Name] = pending
+	batch.list = append(batch.list, pending)
+}
+
+// take returns the pending paths, and resets the batch
+func (batch *fsBatch) take() []*fsPending {
+	list := batch.list
+	batch.list = nil
+	clear(batch.index)
+	return list
+}
+
+// flush turns a list of pending paths into events, and sends them to the callbacks
+func (fw *FSWatcher) flush(ctx context.Context, obj *watcherObj, list []*fsPending) {
+	if len(list) == 0 {
+		return
+	}
+
+	filter := obj.filter
+
+	stats := make([]os.FileInfo, len(list))
+	for i, pending := range list {
+		stats[i], _ = os.Stat(pending.path)
+
+		if filepath.Base(pending.path) == ".gitignore" {
+			filter.loadGitIgnore(filepath.Dir(pending.path))
+		}
+	}
+
+	// pair each renamed path that no longer exists with the path that now has its inode,
+	// since fsnotify reports a rename as a rename of the old path and a create of the new one
+	//
+	// the new path may have been seen before the rename in the same window (like a write before an atomic save),
+	// and a path that was renamed out of the watched directory has no pair
+	oldPath := make([]string, len(list))
+	moved := make([]bool, len(list))
+
+	obj.mu.Lock()
+	for i, pending := range list {
+		if stats[i] != nil || !pending.op.Has(fsnotify.Rename) {
+			continue
+		}
+
+		inode, ok := obj.inodes[pending.path]
+		if !ok {
+			continue
+		}
+
+		for j := range list {
+			if moved[j] || oldPath[j] != "" || stats[j] == nil {
+				continue
+			}
+
+			if id, ok := fileInode(stats[j]); !ok || id != inode {
+				continue
+			}
+
+			isDir := stats[j].IsDir()
+			if filter.emit(pending.path, isDir) && filter.emit(list[j].path, isDir) {
+				oldPath[j] = pending.path
+				list[j].op |= pending.op
+				moved[i] = true
+			}
+			break
+		}
+	}
+
+	// keep the inode of each path, so later renames can be paired
+	for i, pending := range list {
+		if stats[i] == nil {
+			if !moved[i] {
+				movePaths(obj.inodes, pending.path, "")
+			}
+			delete(obj.inodes, pending.path)
+			continue
+		}
+
+		if oldPath[i] != "" {
+			movePaths(obj.inodes, oldPath[i], pending.path)
+		}
+		if id, ok := fileInode(stats[i]); ok {
+			obj.inodes[pending.path] = id
+		}
+	}
+	obj.mu.Unlock()
+
+	events := make([]FSEvent, 0, len(list))
+	for i, pending := range list {
+		if moved[i] {
+			continue
+		}
+
+		event := FSEvent{
+			Path:    pending.path,
+			Op:      fsOp(pending.op),
+			OldPath: oldPath[i],
+			Time:    pending.time,
+			Root:    obj.rootOf(pending.path),
+		}
+
+		if stats[i] == nil {
+			if pending.first.Has(fsnotify.Create) {
+				// created and removed within the same window
+				continue
+			}
+			event.Kind = FSEVENT_REMOVE
+		} else {
+			event.IsDir = stats[i].IsDir()
+			if event.OldPath != "" {
+				event.Kind = FSEVENT_MOVE
+			} else if event.IsDir {
+				event.Kind = FSEVENT_ADD
+			} else {
+				event.Kind = FSEVENT_MODIFY
+			}
+		}
+
+		events = fw.handle(ctx, obj, event, events)
+	}
+
+	if fw.OnBatch != nil && len(events) != 0 {
+		fw.OnBatch(events)
+	}
+
+	fw.mu.Lock()
+	ch := fw.events
+	fw.mu.Unlock()
+
+	if ch != nil {
+		for _, event := range events {
+			select {
+			case ch <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// handle runs the callbacks for an event, and adds it to the list of events to send
+//
+// when a new directory is watched, the paths already inside it are handled as new paths too,
+// since they could have been created before the directory was watched
+func (fw *FSWatcher) handle(ctx context.Context, obj *watcherObj, event FSEvent, events []FSEvent) []FSEvent {
+	if !obj.filter.emit(event.Path, event.IsDir) {
+		return events
+	}
+
+	if obj.hashes != nil {
+		obj.mu.Lock()
+		changed := obj.updateHash(&event)
+		obj.mu.Unlock()
+
+		if !changed {
+			// the content did not change
+			return events
+		}
+	}
+
+	watched := fw.dispatch(ctx, obj.watcher, obj.filter, event)
+	events = append(events, event)
+
+	if watched && event.Kind == FSEVENT_ADD {
+		events = fw.handleDir(ctx, obj, event.Path, events)
+	}
+
+	return events
+}
+
+// handleDir handles the files and directories inside a new directory, as new paths
+func (fw *FSWatcher) handleDir(ctx context.Context, obj *watcherObj, dir string, events []FSEvent) []FSEvent {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return events
+	}
+
+	for _, file := range files {
+		path, err := JoinPath(dir, file.Name())
+		if err != nil {
+			continue
+		}
+
+		stat, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		obj.addInode(path, stat)
+
+		event := FSEvent{
+			Path:  path,
+			Kind:  FSEVENT_MODIFY,
+			Op:    FSOP_CREATE,
+			IsDir: stat.IsDir(),
+			Time:  time.Now(),
+			Root:  obj.rootOf(path),
+		}
+		if event.IsDir {
+			event.Kind = FSEVENT_ADD
+		}
+
+		events = fw.handle(ctx, obj, event, events)
+	}
+
+	return events
+}
+
+// dispatch runs the callbacks for an event, and updates the watched directories
+//
+// directories that cannot be watched are sent to `Errors`
+//
+// returns true if the event is for a directory that is now watched
+func (fw *FSWatcher) dispatch(ctx context.Context, watcher fsBackend, filter *fsWatchFilter, event FSEvent) (watched bool) {
+	op := event.Op.String()
+
+	if event.Kind == FSEVENT_REMOVE || event.Kind == FSEVENT_MOVE {
+		removePath := event.Path
+		if event.Kind == FSEVENT_MOVE {
+			removePath = event.OldPath
+		}
+
+		if fw.OnRemove == nil || fw.OnRemove(removePath, op) {
+			watcher.Remove(removePath)
+		}
+	}
+
+	if event.Kind != FSEVENT_REMOVE {
+		if event.IsDir {
+			if fw.OnDirAdd == nil || fw.OnDirAdd(event.Path, op) {
+				filter.loadGitIgnore(event.Path)
+				if err := watcher.Add(event.Path); err != nil {
+					fw.sendError(ctx, err)
+				} else {
+					watched = true
+				}
+
+				if event.Kind == FSEVENT_MOVE {
+					// the paths inside a moved directory already exist, so only their watches need to move
+					fw.watchDirSub(watcher, filter, event.Path, func(err error) {
+						fw.sendError(ctx, err)
+					})
+				}
+			}
+		} else if fw.OnFileChange != nil {
+			fw.OnFileChange(event.Path, op)
+		}
+	}
+
+	if fw.OnAny != nil {
+		fw.OnAny(event.Path, op)
+	}
+
+	for _, cb := range fw.callbacks() {
+		cb(event.Path, string(event.Kind), op, event.IsDir)
+	}
+
+	return watched
+}
+
+// rootOf returns the root name of the watcher, for an event on a path
+//
+// a watcher of files is shared by each file in a directory, so each file is its own root
+func (obj *watcherObj) rootOf(path string) string {
+	if obj.files {
+		return path
+	}
+	return obj.root
+}
+
+// updateHash updates the content hash of the file in an event, and adds it to the event
+//
+// returns false if the event does not change the content of a file
+//
+// note: the watcher must already be locked
+func (obj *watcherObj) updateHash(event *FSEvent) bool {
+	if event.Kind == FSEVENT_REMOVE {
+		delete(obj.hashes, event.Path)
+		movePaths(obj.hashes, event.Path, "")
+		return true
+	}
+
+	if event.IsDir {
+		if event.Kind == FSEVENT_MOVE {
+			movePaths(obj.hashes, event.OldPath, event.Path)
+		}
+		return true
+	}
+
+	hash, err := hashFile(event.Path)
+	if err != nil {
+		return true
+	}
+	event.Hash = hash
+
+	prev, ok := obj.hashes[event.Path]
+	obj.hashes[event.Path] = hash
+
+	if event.Kind == FSEVENT_MOVE {
+		if _, known := obj.hashes[event.OldPath]; known {
+			// the old path was seen before, so the move must be reported
+			delete(obj.hashes, event.OldPath)
+			return true
+		}
+		// otherwise, a new file was renamed over this one (like an atomic save from an editor)
+	}
+
+	return !ok || prev != hash
+}
+
+// movePaths moves the values of the paths inside a directory to a new directory,
+// or removes them if the new directory is empty
+func movePaths[T any](list map[string]T, oldDir string, newDir string) {
+	prefix := oldDir + string(filepath.Separator)
+	for path, val := range list {
+		if rel, ok := strings.CutPrefix(path, prefix); ok {
+			delete(list, path)
+			if newDir != "" {
+				list[filepath.Join(newDir, rel)] = val
+			}
+		}
+	}
+}
+
+// hashFile returns the sha256 of the content of a file, as a hex string
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,290 @@
+package goutil
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultWatchIgnore is the list of glob patterns that `FileWatcher` excludes by default
+var DefaultWatchIgnore = []string{".git", "node_modules"}
+
+// fsWatchFilter decides which paths under a watched root are walked, watched, and emitted
+type fsWatchFilter struct {
+	root      string
+	include   []fsPattern
+	exclude   []fsPattern
+	gitIgnore bool
+
+	// files is the set of files that `WatchFile` watches in the directory, if set
+	//
+	// note: this is guarded by mu
+	files map[string]struct{}
+
+	// ignore holds the rules of each .gitignore file, by its directory relative to root
+	ignore map[string][]fsPattern
+	mu     sync.RWMutex
+}
+
+// fsPattern is a parsed glob pattern, with the same rules as a .gitignore line
+type fsPattern struct {
+	glob     string
+	anchored bool
+	dirOnly  bool
+	negate   bool
+}
+
+// newFilter makes a filter for a root directory, from the current watcher options
+func (fw *FSWatcher) newFilter(root string) *fsWatchFilter {
+	filter := &fsWatchFilter{
+		root:      root,
+		gitIgnore: fw.GitIgnore,
+		ignore:    map[string][]fsPattern{},
+	}
+
+	for _, glob := range fw.Include {
+		if pattern, ok := parseFSPattern(glob); ok {
+			filter.include = append(filter.include, pattern)
+		}
+	}
+
+	for _, glob := range fw.Exclude {
+		if pattern, ok := parseFSPattern(glob); ok {
+			filter.exclude = append(filter.exclude, pattern)
+		}
+	}
+
+	return filter
+}
+
+// skip returns true if a path, or one of its parent directories, is excluded or ignored
+func (filter *fsWatchFilter) skip(filePath string, isDir bool) bool {
+	filter.mu.RLock()
+	defer filter.mu.RUnlock()
+
+	if filter.files != nil {
+		_, ok := filter.files[filePath]
+		return !ok
+	}
+
+	parts, ok := filter.rel(filePath)
+	if !ok {
+		return false
+	}
+
+	for i := range parts {
+		if filter.skipPart(parts, i+1, i < len(parts)-1 || isDir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// emit returns true if events for a path should be sent to callbacks
+//
+// include patterns only apply to files, so directories are still walked to find them
+func (filter *fsWatchFilter) emit(filePath string, isDir bool) bool {
+	if filter.skip(filePath, isDir) {
+		return false
+	}
+
+	if isDir || len(filter.include) == 0 || filter.files != nil {
+		return true
+	}
+
+	parts, ok := filter.rel(filePath)
+	if !ok {
+		return true
+	}
+
+	rel := strings.Join(parts, "/")
+	for _, pattern := range filter.include {
+		if pattern.match(rel, false) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addFile adds a file to the set of files the filter emits
+func (filter *fsWatchFilter) addFile(filePath string) {
+	filter.mu.Lock()
+	defer filter.mu.Unlock()
+
+	filter.files[filePath] = struct{}{}
+}
+
+// removeFile removes a file from the set of files the filter emits
+//
+// returns the number of files left in the set
+func (filter *fsWatchFilter) removeFile(filePath string) int {
+	filter.mu.Lock()
+	defer filter.mu.Unlock()
+
+	delete(filter.files, filePath)
+	return len(filter.files)
+}
+
+// loadGitIgnore reads the .gitignore file in a directory, if the filter uses them
+func (filter *fsWatchFilter) loadGitIgnore(dir string) {
+	if !filter.gitIgnore {
+		return
+	}
+
+	parts, ok := filter.rel(dir)
+	if !ok && dir != filter.root {
+		return
+	}
+	key := strings.Join(parts, "/")
+
+	var rules []fsPattern
+	if file, err := os.Open(filepath.Join(dir, ".gitignore")); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if pattern, ok := parseFSPattern(scanner.Text()); ok {
+				rules = append(rules, pattern)
+			}
+		}
+		file.Close()
+	}
+
+	filter.mu.Lock()
+	defer filter.mu.Unlock()
+
+	if len(rules) == 0 {
+		delete(filter.ignore, key)
+	} else {
+		filter.ignore[key] = rules
+	}
+}
+
+// skipPart checks the first n parts of a relative path against the exclude and .gitignore rules
+//
+// note: the filter must already be locked for reading
+func (filter *fsWatchFilter) skipPart(parts []string, n int, isDir bool) bool {
+	rel := strings.Join(parts[:n], "/")
+	for _, pattern := range filter.exclude {
+		if pattern.match(rel, isDir) {
+			return true
+		}
+	}
+
+	if len(filter.ignore) == 0 {
+		return false
+	}
+
+	// like git, rules from deeper .gitignore files override rules from parent directories,
+	// and later rules in a file override earlier ones
+	ignored := false
+	for i := 0; i < n; i++ {
+		rules, ok := filter.ignore[strings.Join(parts[:i], "/")]
+		if !ok {
+			continue
+		}
+
+		sub := strings.Join(parts[i:n], "/")
+		for _, rule := range rules {
+			if rule.match(sub, isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+
+	return ignored
+}
+
+// rel splits a path into its parts relative to the root directory
+func (filter *fsWatchFilter) rel(filePath string) ([]string, bool) {
+	rel, err := filepath.Rel(filter.root, filePath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, false
+	}
+
+	return strings.Split(filepath.ToSlash(rel), "/"), true
+}
+
+// parseFSPattern parses a glob pattern, or a line from a .gitignore file
+//
+// @ok: false if the line is empty or a comment
+func parseFSPattern(line string) (pattern fsPattern, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern, false
+	}
+
+	if strings.HasPrefix(line, "!") {
+		pattern.negate = true
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		pattern.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	if strings.Contains(line, "/") {
+		pattern.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	if line == "" {
+		return pattern, false
+	}
+
+	pattern.glob = line
+	return pattern, true
+}
+
+// match returns true if a slash separated relative path matches the pattern
+//
+// patterns without a slash match the name at any depth, and patterns with one match from the root
+func (pattern fsPattern) match(rel string, isDir bool) bool {
+	if pattern.dirOnly && !isDir {
+		return false
+	}
+
+	if !pattern.anchored {
+		ok, _ := path.Match(pattern.glob, path.Base(rel))
+		return ok
+	}
+
+	return matchGlobParts(strings.Split(pattern.glob, "/"), strings.Split(rel, "/"))
+}
+
+// matchGlobParts matches the parts of a path against the parts of a glob pattern
+//
+// a "**" part matches any number of directories
+func matchGlobParts(glob []string, parts []string) bool {
+	for len(glob) != 0 {
+		if glob[0] == "**" {
+			glob = glob[1:]
+			if len(glob) == 0 {
+				return true
+			}
+
+			for i := range parts {
+				if matchGlobParts(glob, parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(parts) == 0 {
+			return false
+		}
+
+		if ok, _ := path.Match(glob[0], parts[0]); !ok {
+			return false
+		}
+
+		glob, parts = glob[1:], parts[1:]
+	}
+
+	return len(parts) == 0
+}
@@ -0,0 +1,436 @@
+package goutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fsBackend is a source of raw file system events, for a list of watched directories
+//
+// like fsnotify, each directory is watched on its own (not recursively)
+type fsBackend interface {
+	Add(path string) error
+	Remove(path string) error
+	Close() error
+
+	events() <-chan fsnotify.Event
+	errors() <-chan error
+
+	// stats returns the number of directories watched with inotify, and the number that are polled
+	stats() (watched int, polled int)
+}
+
+// ErrWatchLimit is sent to `FSWatcher.Errors` when the inotify watch limit is reached,
+// and the directories over the limit are polled instead
+//
+// the limit can be raised with the fs.inotify.max_user_watches sysctl
+var ErrWatchLimit = errors.New("inotify watch limit reached")
+
+// fsNotifyBackend watches directories with fsnotify (inotify on linux),
+// and polls the directories that go over the inotify watch limit
+type fsNotifyBackend struct {
+	watcher *fsnotify.Watcher
+
+	// poller is made when the first directory goes over the watch limit
+	//
+	// note: this is guarded by mu
+	poller   *fsPoller
+	interval time.Duration
+	mu       sync.Mutex
+
+	ch   chan fsnotify.Event
+	errs chan error
+
+	// limit receives the ErrWatchLimit notice, which is only sent once
+	limit chan error
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// newFSNotifyBackend starts a backend that watches directories with fsnotify
+//
+// @interval: how often to poll the directories that go over the inotify watch limit
+func newFSNotifyBackend(interval time.Duration) (*fsNotifyBackend, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	backend := &fsNotifyBackend{
+		watcher:  watcher,
+		interval: interval,
+		ch:       make(chan fsnotify.Event),
+		errs:     make(chan error, 1),
+		limit:    make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+
+	// merge the events and errors from fsnotify
+	backend.wg.Add(1)
+	go func() {
+		defer backend.wg.Done()
+
+		for {
+			var event fsnotify.Event
+			var err error
+			var ok bool
+
+			select {
+			case <-backend.done:
+				return
+			case event, ok = <-watcher.Events:
+			case err, ok = <-watcher.Errors:
+			case err, ok = <-backend.limit:
+			}
+
+			if !ok {
+				return
+			}
+
+			if err != nil {
+				select {
+				case backend.errs <- err:
+				case <-backend.done:
+					return
+				}
+				continue
+			}
+
+			select {
+			case backend.ch <- event:
+			case <-backend.done:
+				return
+			}
+		}
+	}()
+
+	return backend, nil
+}
+
+// Add starts watching a directory
+//
+// if the inotify watch limit has been reached, the directory is polled instead
+func (backend *fsNotifyBackend) Add(path string) error {
+	err := backend.watcher.Add(path)
+	if !errors.Is(err, syscall.ENOSPC) {
+		return err
+	}
+
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+
+	if backend.poller == nil {
+		select {
+		case <-backend.done:
+			return err
+		default:
+		}
+
+		poller := newFSPoller(backend.interval)
+		if err := poller.Add(path); err != nil {
+			poller.Close()
+			return err
+		}
+		backend.poller = poller
+
+		// merge the events from the poller
+		backend.wg.Add(1)
+		go func() {
+			defer backend.wg.Done()
+
+			for {
+				select {
+				case <-backend.done:
+					return
+				case event := <-poller.events():
+					select {
+					case backend.ch <- event:
+					case <-backend.done:
+						return
+					}
+				}
+			}
+		}()
+
+		// only report the first directory, so the errors channel is not flooded
+		//
+		// the limit channel is only written to once, so this never blocks,
+		// and the notice is sent on to the errors channel with the fsnotify errors
+		limit, _ := InotifyWatchLimit()
+		backend.limit <- fmt.Errorf("%w (%d), polling %s and any other directories over the limit", ErrWatchLimit, limit, path)
+		return nil
+	}
+
+	return backend.poller.Add(path)
+}
+
+// Remove stops watching a directory
+func (backend *fsNotifyBackend) Remove(path string) error {
+	backend.mu.Lock()
+	if backend.poller != nil {
+		backend.poller.Remove(path)
+	}
+	backend.mu.Unlock()
+
+	err := backend.watcher.Remove(path)
+	if errors.Is(err, fsnotify.ErrNonExistentWatch) {
+		return nil
+	}
+	return err
+}
+
+// Close stops the backend, and waits for it to finish
+func (backend *fsNotifyBackend) Close() error {
+	backend.closeOnce.Do(func() {
+		close(backend.done)
+	})
+
+	err := backend.watcher.Close()
+
+	backend.mu.Lock()
+	if backend.poller != nil {
+		backend.poller.Close()
+	}
+	backend.mu.Unlock()
+
+	backend.wg.Wait()
+	return err
+}
+
+func (backend *fsNotifyBackend) events() <-chan fsnotify.Event {
+	return backend.ch
+}
+
+func (backend *fsNotifyBackend) errors() <-chan error {
+	return backend.errs
+}
+
+func (backend *fsNotifyBackend) stats() (watched int, polled int) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+
+	if backend.poller != nil {
+		polled = backend.poller.len()
+	}
+	return len(backend.watcher.WatchList()), polled
+}
+
+// fsPoller watches directories by comparing the mtime, size and inode of their files on an interval
+//
+// this works on file systems that do not support inotify (like NFS, FUSE, and some container mounts)
+type fsPoller struct {
+	// dirs holds the files in each watched directory, by name
+	dirs map[string]map[string]fsFingerprint
+	mu   sync.Mutex
+
+	ch   chan fsnotify.Event
+	errs chan error
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// fsFingerprint is the state of a file, used to detect changes while polling
+type fsFingerprint struct {
+	modTime time.Time
+	size    int64
+	mode    os.FileMode
+	inode   uint64
+}
+
+// newFSPoller starts a poller that checks its watched directories on an interval
+func newFSPoller(interval time.Duration) *fsPoller {
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	poller := &fsPoller{
+		dirs: map[string]map[string]fsFingerprint{},
+		ch:   make(chan fsnotify.Event),
+		errs: make(chan error),
+		done: make(chan struct{}),
+	}
+
+	poller.wg.Add(1)
+	go func() {
+		defer poller.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-poller.done:
+				return
+			case <-ticker.C:
+				for _, event := range poller.poll() {
+					select {
+					case poller.ch <- event:
+					case <-poller.done:
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return poller
+}
+
+// Add starts watching a directory
+func (poller *fsPoller) Add(path string) error {
+	files, err := scanFingerprints(path)
+	if err != nil {
+		return err
+	}
+
+	poller.mu.Lock()
+	defer poller.mu.Unlock()
+
+	poller.dirs[filepath.Clean(path)] = files
+	return nil
+}
+
+// Remove stops watching a directory
+func (poller *fsPoller) Remove(path string) error {
+	poller.mu.Lock()
+	defer poller.mu.Unlock()
+
+	delete(poller.dirs, filepath.Clean(path))
+	return nil
+}
+
+// Close stops the poller, and waits for it to finish
+func (poller *fsPoller) Close() error {
+	poller.closeOnce.Do(func() {
+		close(poller.done)
+	})
+	poller.wg.Wait()
+	return nil
+}
+
+func (poller *fsPoller) events() <-chan fsnotify.Event {
+	return poller.ch
+}
+
+func (poller *fsPoller) errors() <-chan error {
+	return poller.errs
+}
+
+func (poller *fsPoller) stats() (watched int, polled int) {
+	return 0, poller.len()
+}
+
+// len returns the number of polled directories
+func (poller *fsPoller) len() int {
+	poller.mu.Lock()
+	defer poller.mu.Unlock()
+
+	return len(poller.dirs)
+}
+
+// poll compares each watched directory with its last state, and returns the changes
+//
+// events are returned in the same order fsnotify would send them,
+// so a rename is a Rename of the old path followed by a Create of the new path
+func (poller *fsPoller) poll() []fsnotify.Event {
+	poller.mu.Lock()
+	defer poller.mu.Unlock()
+
+	var renamed, created, written, removed []fsnotify.Event
+	missing := map[uint64]string{}
+	var added []string
+	addedInode := map[string]uint64{}
+
+	for dir, old := range poller.dirs {
+		files, err := scanFingerprints(dir)
+		if err != nil {
+			// the directory was removed, so its files were too
+			files = map[string]fsFingerprint{}
+		}
+
+		for name, fp := range files {
+			path := filepath.Join(dir, name)
+			prev, ok := old[name]
+
+			switch {
+			case !ok || prev.inode != fp.inode:
+				added = append(added, path)
+				addedInode[path] = fp.inode
+				if ok {
+					missing[prev.inode] = path
+				}
+			case !fp.mode.IsDir() && (!prev.modTime.Equal(fp.modTime) || prev.size != fp.size):
+				// like inotify, changes inside a subdirectory are not a change to the subdirectory
+				written = append(written, fsnotify.Event{Name: path, Op: fsnotify.Write})
+			case prev.mode != fp.mode:
+				written = append(written, fsnotify.Event{Name: path, Op: fsnotify.Chmod})
+			}
+		}
+
+		for name, prev := range old {
+			if _, ok := files[name]; !ok {
+				missing[prev.inode] = filepath.Join(dir, name)
+			}
+		}
+
+		poller.dirs[dir] = files
+	}
+
+	for _, path := range added {
+		if oldPath, ok := missing[addedInode[path]]; ok && oldPath != path {
+			renamed = append(renamed, fsnotify.Event{Name: oldPath, Op: fsnotify.Rename})
+			delete(missing, addedInode[path])
+		}
+		created = append(created, fsnotify.Event{Name: path, Op: fsnotify.Create})
+	}
+
+	for _, path := range missing {
+		if _, err := os.Lstat(path); err != nil {
+			removed = append(removed, fsnotify.Event{Name: path, Op: fsnotify.Remove})
+		}
+	}
+
+	events := append(renamed, created...)
+	events = append(events, written...)
+	return append(events, removed...)
+}
+
+// scanFingerprints returns the state of each file in a directory, by name
+func scanFingerprints(dir string) (map[string]fsFingerprint, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]fsFingerprint, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		fp := fsFingerprint{
+			modTime: info.ModTime(),
+			size:    info.Size(),
+			mode:    info.Mode(),
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			fp.inode = stat.Ino
+		}
+
+		files[entry.Name()] = fp
+	}
+
+	return files, nil
+}
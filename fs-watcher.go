@@ -1,25 +1,104 @@
 package goutil
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
 
-const FSEVENT_ADD = "add"
-const FSEVENT_MODIFY = "modify"
-const FSEVENT_REMOVE = "remove"
+// FSEventKind is the kind of change an `FSEvent` reports
+type FSEventKind string
+
+// the values of `FSEventKind`
+//
+// these are untyped, so they can still be compared with the event string passed to `On` callbacks
+const (
+	FSEVENT_ADD    = "add"
+	FSEVENT_MODIFY = "modify"
+	FSEVENT_REMOVE = "remove"
+	FSEVENT_MOVE   = "move"
+)
+
+// FSOp is a set of raw file system operations, that were coalesced into an `FSEvent`
+type FSOp uint32
+
+// the operations in an `FSOp`, with the same values as fsnotify
+const (
+	FSOP_CREATE FSOp = 1 << iota
+	FSOP_WRITE
+	FSOP_REMOVE
+	FSOP_RENAME
+	FSOP_CHMOD
+)
+
+// fsOps are the name and fsnotify operation of each `FSOp`
+var fsOps = []struct {
+	op     FSOp
+	notify fsnotify.Op
+	name   string
+}{
+	{FSOP_CREATE, fsnotify.Create, "CREATE"},
+	{FSOP_WRITE, fsnotify.Write, "WRITE"},
+	{FSOP_REMOVE, fsnotify.Remove, "REMOVE"},
+	{FSOP_RENAME, fsnotify.Rename, "RENAME"},
+	{FSOP_CHMOD, fsnotify.Chmod, "CHMOD"},
+}
+
+// Has returns true if the set includes an operation
+func (op FSOp) Has(h FSOp) bool {
+	return op&h == h
+}
+
+// String returns the operations in the set, joined with "|" (example: "CREATE|WRITE")
+func (op FSOp) String() string {
+	var names []string
+	for _, o := range fsOps {
+		if op.Has(o.op) {
+			names = append(names, o.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// fsOp converts an fsnotify operation to an `FSOp`
+func fsOp(op fsnotify.Op) FSOp {
+	var res FSOp
+	for _, o := range fsOps {
+		if op.Has(o.notify) {
+			res |= o.op
+		}
+	}
+	return res
+}
 
 // A watcher instance for the `FS.FSWatcher` method
 type FSWatcher struct {
 	watcherList *map[string]*watcherObj
 	mu          sync.Mutex
-	size        *uint
+	size        uint
+
+	// fileDirs holds the watcher of each directory with files passed to `WatchFile`,
+	// so files in the same directory share one watcher
+	fileDirs map[string]*watcherObj
+	fileMu   sync.Mutex
+
+	// idle is closed when the last watcher stops
+	idle chan struct{}
 
 	eventCB []func(path string, event string, op string, isDir bool)
+	events  chan FSEvent
+	errors  chan error
+
+	// dropped counts the errors found while setting up a watcher, that did not fit in the `Errors` channel
+	dropped atomic.Uint64
 
 	// when a file changes
 	//
@@ -52,19 +131,93 @@ type FSWatcher struct {
 	//
 	// @op: the change operation
 	OnAny func(path string, op string)
+
+	// OnBatch receives all the events from a debounce window at once,
+	// after the other callbacks have run for each event
+	OnBatch func(events []FSEvent)
+
+	// Debounce is how long a path must go quiet before its events are sent
+	//
+	// events for the same path within the window are coalesced into one event,
+	// and a rename within the watched directory is sent as a single `FSEVENT_MOVE` event
+	// (renames are paired by inode, so a file moved out of the directory is sent as `FSEVENT_REMOVE`)
+	//
+	// default: 100ms (0 sends every event right away)
+	Debounce time.Duration
+
+	// glob patterns of the files to include, relative to the watched directory
+	//
+	// patterns without a "/" match the file name at any depth, and "**" matches any number of directories
+	//
+	// directories are always walked, so included files inside them can be found
+	//
+	// default: all files are included
+	Include []string
+
+	// glob patterns of the files and directories to exclude, relative to the watched directory
+	//
+	// excluded directories are not walked or watched
+	//
+	// default: `DefaultWatchIgnore`
+	Exclude []string
+
+	// GitIgnore also excludes the files and directories listed in any .gitignore files found in the watched directory
+	//
+	// note: Include, Exclude, and GitIgnore are read when `WatchDir` is called
+	GitIgnore bool
+
+	// HashContent keeps a hash of the content of each file, from the initial scan onward,
+	// and skips modify events when the content has not changed
+	//
+	// the hash is included in `FSEvent.Hash`
+	//
+	// note: every changed file is read in full, so this is slower for large files
+	HashContent bool
+
+	// PollInterval is how often directories are checked for changes, when they are polled
+	//
+	// directories are polled by `WatchDirPoll`, or when inotify cannot watch them
+	//
+	// default: 1s
+	PollInterval time.Duration
 }
 
 type watcherObj struct {
-	watcher *fsnotify.Watcher
-	close   *bool
+	watcher fsBackend
+	cancel  context.CancelFunc
+	filter  *fsWatchFilter
+	root    string
+
+	// files is true if the watcher only watches the files passed to `WatchFile`
+	files bool
+
+	// hashes holds the content hash of each file, when `HashContent` is enabled
+	//
+	// note: this is guarded by mu
+	hashes map[string]string
+
+	// inodes holds the device and inode of each known path, to pair renames
+	//
+	// note: this is guarded by mu
+	inodes map[string]fsInode
+
+	mu sync.Mutex
+
+	// stops holds a function for each file passed to `WatchFileContext`,
+	// that stops waiting for its context to be done
+	//
+	// note: this is guarded by the mutex of the FSWatcher
+	stops map[string]func() bool
 }
 
 // FileWatcher creates a new file watcher
 func FileWatcher() *FSWatcher {
-	size := uint(0)
 	return &FSWatcher{
-		watcherList: &map[string]*watcherObj{},
-		size:        &size,
+		watcherList:  &map[string]*watcherObj{},
+		fileDirs:     map[string]*watcherObj{},
+		Exclude:      slices.Clone(DefaultWatchIgnore),
+		Debounce:     100 * time.Millisecond,
+		PollInterval: time.Second,
 	}
 }
 
@@ -72,112 +225,348 @@ func FileWatcher() *FSWatcher {
 //
 // @nosub: do not watch sub directories
 func (fw *FSWatcher) WatchDir(root string, nosub ...bool) error {
+	return fw.WatchDirContext(context.Background(), root, nosub...)
+}
+
+// WatchDirContext watches the files in a directory and its subdirectories for changes,
+// until the context is canceled or the watcher is closed
+//
+// @nosub: do not watch sub directories
+func (fw *FSWatcher) WatchDirContext(ctx context.Context, root string, nosub ...bool) error {
+	return fw.watchDir(ctx, root, false, nosub)
+}
+
+// WatchDirPoll watches the files in a directory and its subdirectories for changes,
+// by checking their mtime, size and inode every `PollInterval`
+//
+// use this for file systems where inotify never fires (like NFS, FUSE, and some container mounts)
+//
+// @nosub: do not watch sub directories
+func (fw *FSWatcher) WatchDirPoll(root string, nosub ...bool) error {
+	return fw.WatchDirPollContext(context.Background(), root, nosub...)
+}
+
+// WatchDirPollContext is like `WatchDirPoll`,
+// but it stops when the context is canceled or the watcher is closed
+//
+// @nosub: do not watch sub directories
+func (fw *FSWatcher) WatchDirPollContext(ctx context.Context, root string, nosub ...bool) error {
+	return fw.watchDir(ctx, root, true, nosub)
+}
+
+// WatchFile watches a single file for changes
+//
+// the parent directory is watched instead of the file,
+// so the file can be created, removed, or replaced by an atomic rename (like a save from an editor)
+//
+// the file does not need to exist yet, but its parent directory does
+//
+// files in the same directory share one watcher, which stops once none of its files are watched
+//
+// use the file path with `CloseWatcher` to stop watching it
+func (fw *FSWatcher) WatchFile(path string) error {
+	return fw.WatchFileContext(context.Background(), path)
+}
+
+// WatchFileContext is like `WatchFile`,
+// but it stops when the context is canceled or the watcher is closed
+func (fw *FSWatcher) WatchFileContext(ctx context.Context, path string) error {
 	var err error
-	if root, err = filepath.Abs(root); err != nil {
+	if path, err = filepath.Abs(path); err != nil {
 		return err
 	}
 
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	fw.initDir(root)
+	dir := filepath.Dir(path)
+
+	fw.fileMu.Lock()
+	defer fw.fileMu.Unlock()
 
-	runClose := false
+	for {
+		fw.mu.Lock()
+		obj := fw.fileDirs[dir]
+		fw.mu.Unlock()
 
+		if obj == nil {
+			// the watcher of the directory is not tied to the context of any one file
+			if obj, err = fw.startWatcher(context.Background(), dir, true, false); err != nil {
+				return err
+			}
+		}
+
+		if fw.addFile(ctx, obj, path) {
+			return nil
+		}
+		// the watcher stopped before the file was added, so start a new one
+	}
+}
+
+// addFile adds a file to the watcher of its directory, and runs the init callbacks for it
+//
+// returns false if the watcher has already stopped
+func (fw *FSWatcher) addFile(ctx context.Context, obj *watcherObj, path string) bool {
 	fw.mu.Lock()
-	(*fw.watcherList)[root] = &watcherObj{watcher: watcher, close: &runClose}
-	*fw.size++
+	if fw.fileDirs[obj.root] != obj {
+		fw.mu.Unlock()
+		return false
+	}
+
+	obj.filter.addFile(path)
+	(*fw.watcherList)[path] = obj
+
+	if stop, ok := obj.stops[path]; ok {
+		stop()
+	}
+	obj.stops[path] = context.AfterFunc(ctx, func() {
+		fw.CloseWatcher(path)
+	})
 	fw.mu.Unlock()
 
-	lastRun := NewCache[string, int64](10 * time.Second)
+	stat, err := os.Stat(path)
+	if err != nil || stat.IsDir() {
+		return true
+	}
 
-	go func() {
-		defer watcher.Close()
-		for {
-			if runClose {
-				break
-			}
+	obj.addInode(path, stat)
+	if obj.hashes != nil {
+		if hash, err := hashFile(path); err == nil {
+			obj.setHash(path, hash)
+		}
+	}
 
-			if event, ok := <-watcher.Events; ok {
-				filePath := event.Name
+	for _, cb := range fw.callbacks() {
+		cb(path, FSEVENT_ADD, "init", false)
+	}
 
-				// prevent duplicate runs
-				now := time.Now().UnixMilli()
-				if last, err := lastRun.Get(filePath); err == nil {
-					if now-last < 100 {
-						continue
-					}
-				}
-				lastRun.Set(filePath, now, nil)
+	return true
+}
 
-				go func(filePath string, op string) {
-					time.Sleep(100 * time.Millisecond)
+// removeFile stops watching a file, and stops the watcher of its directory if it was the last file
+//
+// note: the mutex of the FSWatcher must already be locked
+func (fw *FSWatcher) removeFile(obj *watcherObj, path string) {
+	delete(*fw.watcherList, path)
 
-					stat, err := os.Stat(filePath)
-					event := FSEVENT_MODIFY
-					if err != nil {
-						event = FSEVENT_REMOVE
-						if fw.OnRemove == nil || fw.OnRemove(filePath, op) {
-							watcher.Remove(filePath)
-						}
-					} else if stat.IsDir() {
-						event = FSEVENT_ADD
-						if fw.OnDirAdd == nil || fw.OnDirAdd(filePath, op) {
-							watcher.Add(filePath)
-						}
-					} else {
-						if fw.OnFileChange != nil {
-							fw.OnFileChange(filePath, op)
-						}
-					}
+	if stop, ok := obj.stops[path]; ok {
+		stop()
+		delete(obj.stops, path)
+	}
 
-					if fw.OnAny != nil {
-						fw.OnAny(filePath, op)
-					}
+	if obj.filter.removeFile(path) == 0 {
+		if fw.fileDirs[obj.root] == obj {
+			delete(fw.fileDirs, obj.root)
+		}
+		obj.cancel()
+	}
+}
 
-					for _, cb := range fw.eventCB {
-						cb(filePath, event, op, err == nil && stat.IsDir())
-					}
-				}(filePath, event.Op.String())
-			}
+// WatchPaths watches a list of files and directories, with the same callbacks
+//
+// directories are watched with `WatchDir` (including their subdirectories), and anything else with `WatchFile`
+//
+// every path is tried, and the errors are joined together
+func (fw *FSWatcher) WatchPaths(paths ...string) error {
+	var errs []error
+	for _, path := range paths {
+		var err error
+		if stat, e := os.Stat(path); e == nil && stat.IsDir() {
+			err = fw.WatchDir(path)
+		} else {
+			err = fw.WatchFile(path)
 		}
-	}()
 
-	err = watcher.Add(root)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// watchDir watches a directory with fsnotify, or by polling it
+func (fw *FSWatcher) watchDir(ctx context.Context, dir string, poll bool, nosub []bool) error {
+	var err error
+	if dir, err = filepath.Abs(dir); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	obj, err := fw.startWatcher(ctx, dir, false, poll)
 	if err != nil {
 		return err
 	}
 
 	if len(nosub) == 0 || nosub[0] {
-		fw.watchDirSub(watcher, root)
+		// this runs on the goroutine of the caller, which may not be reading the errors yet
+		fw.watchDirSub(obj.watcher, obj.filter, dir, fw.trySendError)
 	}
 
 	return nil
 }
 
-func (fw *FSWatcher) initDir(dir string) {
+// startWatcher makes a watcher for a directory, and starts its goroutine
+//
+// @files: only watch the files added with `addFile`, instead of the whole directory
+func (fw *FSWatcher) startWatcher(ctx context.Context, dir string, files bool, poll bool) (*watcherObj, error) {
+	watcher, err := fw.newBackend(dir, poll)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	obj := &watcherObj{watcher: watcher, cancel: cancel, filter: fw.newFilter(dir), root: dir, inodes: map[string]fsInode{}}
+	if fw.HashContent {
+		obj.hashes = map[string]string{}
+	}
+
+	if files {
+		obj.files = true
+		obj.filter.files = map[string]struct{}{}
+		obj.stops = map[string]func() bool{}
+	} else {
+		fw.initDir(obj, dir)
+	}
+
+	fw.mu.Lock()
+	if files {
+		fw.fileDirs[dir] = obj
+	} else {
+		if old, ok := (*fw.watcherList)[dir]; ok {
+			old.cancel()
+		}
+		(*fw.watcherList)[dir] = obj
+	}
+	if fw.size == 0 {
+		fw.idle = make(chan struct{})
+	}
+	fw.size++
+	fw.mu.Unlock()
+
+	debounce := fw.Debounce
+
+	go func() {
+		defer fw.release(obj)
+
+		batch := fsBatch{index: map[string]*fsPending{}}
+
+		timer := time.NewTimer(debounce)
+		defer timer.Stop()
+		timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.events():
+				if !ok {
+					return
+				}
+
+				batch.add(event)
+
+				if debounce <= 0 {
+					fw.flush(ctx, obj, batch.take())
+				} else if time.Since(batch.start) < fsMaxDebounce*debounce {
+					// keep waiting until the path goes quiet, up to a limit
+					timer.Reset(debounce)
+				}
+			case <-timer.C:
+				fw.flush(ctx, obj, batch.take())
+			case err, ok := <-watcher.errors():
+				if !ok {
+					return
+				}
+				fw.sendError(ctx, err)
+			}
+		}
+	}()
+
+	return obj, nil
+}
+
+// newBackend makes a backend that watches the root directory,
+// and falls back to polling when fsnotify cannot watch it
+func (fw *FSWatcher) newBackend(root string, poll bool) (fsBackend, error) {
+	if !poll {
+		if backend, err := newFSNotifyBackend(fw.PollInterval); err == nil {
+			if err := backend.watcher.Add(root); err == nil {
+				return backend, nil
+			}
+			backend.Close()
+		}
+	}
+
+	poller := newFSPoller(fw.PollInterval)
+	if err := poller.Add(root); err != nil {
+		poller.Close()
+		return nil, err
+	}
+	return poller, nil
+}
+
+func (fw *FSWatcher) initDir(obj *watcherObj, dir string) {
+	filter := obj.filter
+
+	filter.loadGitIgnore(dir)
+
 	if files, err := os.ReadDir(dir); err == nil {
 		for _, file := range files {
 			if path, err := JoinPath(dir, file.Name()); err == nil {
+				if !filter.emit(path, file.IsDir()) {
+					continue
+				}
+
+				if stat, err := os.Stat(path); err == nil {
+					obj.addInode(path, stat)
+				}
+
 				if !file.IsDir() {
-					for _, cb := range fw.eventCB {
+					if obj.hashes != nil {
+						if hash, err := hashFile(path); err == nil {
+							obj.setHash(path, hash)
+						}
+					}
+
+					for _, cb := range fw.callbacks() {
 						cb(path, FSEVENT_ADD, "init", false)
 					}
 				} else {
-					for _, cb := range fw.eventCB {
+					for _, cb := range fw.callbacks() {
 						cb(path, FSEVENT_ADD, "init", true)
 					}
 
-					fw.initDir(path)
+					fw.initDir(obj, path)
 				}
 			}
 		}
 	}
 }
 
-func (fw *FSWatcher) watchDirSub(watcher *fsnotify.Watcher, dir string) {
+// addInode keeps the device and inode of a path, so a rename of it can be paired
+func (obj *watcherObj) addInode(path string, stat os.FileInfo) {
+	if id, ok := fileInode(stat); ok {
+		obj.mu.Lock()
+		obj.inodes[path] = id
+		obj.mu.Unlock()
+	}
+}
+
+// setHash keeps the content hash of a file
+func (obj *watcherObj) setHash(path string, hash string) {
+	obj.mu.Lock()
+	obj.hashes[path] = hash
+	obj.mu.Unlock()
+}
+
+// watchDirSub watches the subdirectories of a directory
+//
+// directories that cannot be watched are skipped, and their errors are passed to report
+func (fw *FSWatcher) watchDirSub(watcher fsBackend, filter *fsWatchFilter, dir string, report func(err error)) {
 	files, err := os.ReadDir(dir)
 	if err != nil {
 		return
@@ -186,8 +575,15 @@ func (fw *FSWatcher) watchDirSub(watcher *fsnotify.Watcher, dir string) {
 	for _, file := range files {
 		if file.IsDir() {
 			if path, err := JoinPath(dir, file.Name()); err == nil {
-				watcher.Add(path)
-				fw.watchDirSub(watcher, path)
+				if filter.skip(path, true) {
+					continue
+				}
+
+				filter.loadGitIgnore(path)
+				if err := watcher.Add(path); err != nil {
+					report(err)
+				}
+				fw.watchDirSub(watcher, filter, path, report)
 			}
 		}
 	}
@@ -202,10 +598,10 @@ func (fw *FSWatcher) CloseWatcher(root string) error {
 
 	if root == "" || root == "*" {
 		for r, w := range *fw.watcherList {
-			*w.close = true
+			w.cancel()
 			delete(*fw.watcherList, r)
-			*fw.size--
 		}
+		clear(fw.fileDirs)
 	} else {
 		var err error
 		if root, err = filepath.Abs(root); err != nil {
@@ -213,23 +609,191 @@ func (fw *FSWatcher) CloseWatcher(root string) error {
 		}
 
 		if w, ok := (*fw.watcherList)[root]; ok {
-			*w.close = true
-			delete(*fw.watcherList, root)
-			*fw.size--
+			if w.files {
+				fw.removeFile(w, root)
+			} else {
+				w.cancel()
+				delete(*fw.watcherList, root)
+			}
 		}
 	}
 
 	return nil
 }
 
+// Close closes all the watchers, and waits for them to stop
+//
+// the `Events` and `Errors` channels are closed once every watcher has stopped
+func (fw *FSWatcher) Close() error {
+	fw.CloseWatcher("*")
+	fw.Wait()
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	if fw.events != nil {
+		close(fw.events)
+		fw.events = nil
+	}
+
+	if fw.errors != nil {
+		close(fw.errors)
+		fw.errors = nil
+	}
+
+	return nil
+}
+
 // Wait for all Watchers to close
 func (fw *FSWatcher) Wait() {
-	for *fw.size != 0 {
-		time.Sleep(100 * time.Millisecond)
+	fw.mu.Lock()
+	if fw.size == 0 {
+		fw.mu.Unlock()
+		return
 	}
+	idle := fw.idle
+	fw.mu.Unlock()
+
+	<-idle
+}
+
+// FSWatchStats is the state of a path passed to `WatchDir` or `WatchFile`
+type FSWatchStats struct {
+	// the number of directories watched with inotify
+	Watched int
+
+	// the number of directories that are polled,
+	// because they were passed to `WatchDirPoll`, or went over the inotify watch limit
+	Polled int
+}
+
+// Stats returns the state of each watched root path
+func (fw *FSWatcher) Stats() map[string]FSWatchStats {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	stats := make(map[string]FSWatchStats, len(*fw.watcherList))
+	for root, w := range *fw.watcherList {
+		watched, polled := w.watcher.stats()
+		stats[root] = FSWatchStats{Watched: watched, Polled: polled}
+	}
+	return stats
+}
+
+// release closes a watcher after its goroutine stops, and removes it from the watcher list
+func (fw *FSWatcher) release(obj *watcherObj) {
+	obj.cancel()
+	obj.watcher.Close()
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	// a watcher of files is listed once for each of its files
+	for root, w := range *fw.watcherList {
+		if w == obj {
+			delete(*fw.watcherList, root)
+		}
+	}
+
+	if obj.files {
+		if fw.fileDirs[obj.root] == obj {
+			delete(fw.fileDirs, obj.root)
+		}
+
+		for _, stop := range obj.stops {
+			stop()
+		}
+		clear(obj.stops)
+	}
+
+	fw.size--
+	if fw.size == 0 {
+		close(fw.idle)
+	}
+}
+
+// Events returns a channel that receives each event, after it is debounced
+//
+// events from the initial scan of a directory are only sent to the `On` callbacks,
+// but the paths inside a directory that is added later are sent as new paths
+//
+// note: once this method is called, the channel must be read, or the watcher will block
+func (fw *FSWatcher) Events() <-chan FSEvent {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	if fw.events == nil {
+		fw.events = make(chan FSEvent, 64)
+	}
+	return fw.events
+}
+
+// Errors returns a channel that receives the errors from the underlying watchers
+//
+// errors found while `WatchDir` sets up a watcher are dropped if the channel is full (see `DroppedErrors`)
+//
+// note: once this method is called, the channel must be read, or the watcher will block
+func (fw *FSWatcher) Errors() <-chan error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	if fw.errors == nil {
+		fw.errors = make(chan error, 16)
+	}
+	return fw.errors
+}
+
+// sendError sends an error to the `Errors` channel, if it is being used
+func (fw *FSWatcher) sendError(ctx context.Context, err error) {
+	fw.mu.Lock()
+	ch := fw.errors
+	fw.mu.Unlock()
+
+	if ch != nil {
+		select {
+		case ch <- err:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// trySendError sends an error to the `Errors` channel, if it is being used and has room
+//
+// errors that do not fit are counted by `DroppedErrors`
+func (fw *FSWatcher) trySendError(err error) {
+	fw.mu.Lock()
+	ch := fw.errors
+	fw.mu.Unlock()
+
+	if ch != nil {
+		select {
+		case ch <- err:
+		default:
+			fw.dropped.Add(1)
+		}
+	}
+}
+
+// DroppedErrors returns the number of errors that were dropped because the `Errors` channel was full
+//
+// errors are only dropped while `WatchDir` is setting up a watcher,
+// since it runs before the channel can be read by the caller
+func (fw *FSWatcher) DroppedErrors() uint64 {
+	return fw.dropped.Load()
+}
+
+// callbacks returns the list of callbacks added with `On`
+func (fw *FSWatcher) callbacks() []func(path string, event string, op string, isDir bool) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	return fw.eventCB
 }
 
 // On adds a new callback to be run when a file or directory changes
 func (fw *FSWatcher) On(cb func(path string, event string, op string, isDir bool)) {
-	fw.eventCB = append(fw.eventCB, cb)
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	fw.eventCB = append(slices.Clip(fw.eventCB), cb)
 }
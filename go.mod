@@ -1,6 +1,6 @@
 module github.com/tkdeng/goutil
 
-go 1.22.6
+go 1.23
 
 require (
 	github.com/fsnotify/fsnotify v1.7.0
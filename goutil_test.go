@@ -1,9 +1,691 @@
 package goutil
 
 import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
 )
 
 func Test(t *testing.T) {
 
 }
+
+func TestCacheTouch(t *testing.T) {
+	cache := NewCache[string, int](time.Hour, CacheOptions[string, int]{MaxSize: 2, NoSweep: true})
+
+	cache.Set("a", 1, nil)
+	if cache.Touch("ghost") {
+		t.Error("expected Touch to return false for a missing key")
+	}
+	cache.Set("b", 2, nil)
+
+	if !cache.Has("a") || !cache.Has("b") {
+		t.Error("expected a missing key to not take up space in the cache")
+	}
+
+	if !cache.Touch("a") {
+		t.Error("expected Touch to return true for an existing key")
+	}
+
+	if size := cache.Stats().Size; size != 2 {
+		t.Errorf("expected size 2, got %d", size)
+	}
+}
+
+func TestCachePolicy(t *testing.T) {
+	tests := []struct {
+		policy  CachePolicy
+		evicted string
+	}{
+		// "b" was used before "a" and "c"
+		{CACHE_LRU, "b"},
+		// "c" was used the least
+		{CACHE_LFU, "c"},
+		// "a" was added first
+		{CACHE_FIFO, "a"},
+	}
+
+	for _, test := range tests {
+		cache := NewCache[string, int](time.Hour, CacheOptions[string, int]{MaxSize: 3, Policy: test.policy, NoSweep: true})
+
+		var evicted []string
+		cache.OnEvict(func(key string, value int, reason EvictReason) {
+			if reason == EVICT_CAPACITY {
+				evicted = append(evicted, key)
+			}
+		})
+
+		cache.Set("a", 1, nil)
+		cache.Set("b", 2, nil)
+		cache.Set("c", 3, nil)
+
+		for _, key := range []string{"b", "b", "a", "a", "c"} {
+			time.Sleep(time.Millisecond)
+			cache.Get(key)
+		}
+		cache.Set("d", 4, nil)
+
+		if len(evicted) != 1 || evicted[0] != test.evicted {
+			t.Errorf("policy %d: expected %q to be evicted, got %v", test.policy, test.evicted, evicted)
+		}
+
+		if size := cache.Stats().Size; size != 3 {
+			t.Errorf("policy %d: expected size 3, got %d", test.policy, size)
+		}
+	}
+}
+
+func TestCacheMaxCost(t *testing.T) {
+	cache := NewCache[string, string](time.Hour, CacheOptions[string, string]{
+		MaxCost: 10,
+		Sizer:   func(key string, value string) int64 { return int64(len(value)) },
+		NoSweep: true,
+	})
+
+	cache.Set("a", "1234", nil)
+	cache.Set("b", "1234", nil)
+	cache.Get("a")
+	cache.Set("c", "1234", nil)
+
+	if cache.Has("b") || !cache.Has("a") || !cache.Has("c") {
+		t.Error("expected the least recently used item to be evicted")
+	}
+
+	cache.Set("d", "12345678901", nil)
+	if cache.Has("d") {
+		t.Error("expected an item larger than MaxCost to be evicted")
+	}
+}
+
+func TestShardedCacheFloatKeys(t *testing.T) {
+	floats := NewShardedCache[float64, int](64, time.Hour, CacheOptions[float64, int]{NoSweep: true})
+	floats.Set(0.0, 1, nil)
+	if val, _ := floats.Get(math.Copysign(0, -1)); val != 1 {
+		t.Errorf("expected -0 to find the value set for +0, got %d", val)
+	}
+
+	complexes := NewShardedCache[complex128, int](64, time.Hour, CacheOptions[complex128, int]{NoSweep: true})
+	complexes.Set(complex(0, 0), 1, nil)
+	if val, _ := complexes.Get(complex(math.Copysign(0, -1), math.Copysign(0, -1))); val != 1 {
+		t.Errorf("expected -0-0i to find the value set for 0+0i, got %d", val)
+	}
+}
+
+func TestCacheSpillDir(t *testing.T) {
+	dir := t.TempDir()
+
+	first := NewCache[string, string](time.Hour, CacheOptions[string, string]{SpillDir: dir, NoSweep: true})
+	first.Set("a", "first", nil)
+	first.delOld(0, EVICT_LOW_MEMORY)
+
+	second := NewCache[string, string](time.Hour, CacheOptions[string, string]{SpillDir: dir, NoSweep: true})
+	second.Set("a", "second", nil)
+	second.delOld(0, EVICT_LOW_MEMORY)
+
+	if val, _ := first.Get("a"); val != "first" {
+		t.Error("expected a cache sharing the SpillDir to keep its own files, got", val)
+	}
+
+	first.Close()
+
+	if val, _ := second.Get("a"); val != "second" {
+		t.Error("expected closing a cache to leave the other SpillDir files, got", val)
+	}
+
+	second.Close()
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "cache-*")); len(files) != 0 {
+		t.Error("expected closed caches to remove their spill files, got", files)
+	}
+}
+
+func TestCacheAutoSave(t *testing.T) {
+	dir := t.TempDir()
+
+	caches := make([]*CacheMap[string, int], 3)
+	for i := range caches {
+		caches[i] = NewCache[string, int](time.Hour, CacheOptions[string, int]{
+			SnapshotFile:     filepath.Join(dir, strconv.Itoa(i)+".gob"),
+			SnapshotInterval: time.Millisecond,
+			NoSweep:          true,
+		})
+		caches[i].Set("key", i, nil)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	caches[0].Close()
+	caches[2].Close()
+	time.Sleep(10 * time.Millisecond)
+	caches[1].Close()
+
+	for i := range caches {
+		restored := NewCache[string, int](time.Hour, CacheOptions[string, int]{
+			SnapshotFile: filepath.Join(dir, strconv.Itoa(i)+".gob"),
+			NoSweep:      true,
+		})
+
+		if val, _ := restored.Get("key"); val != i {
+			t.Errorf("cache %d: expected %d to be restored, got %d", i, i, val)
+		}
+	}
+}
+
+func TestSelfCgroupPath(t *testing.T) {
+	v2 := "0::/system.slice/app.service\n"
+	if path := selfCgroupPath(v2, false); path != "/system.slice/app.service" {
+		t.Errorf("expected the v2 cgroup path, got %q", path)
+	}
+
+	v1 := "5:cpu,cpuacct:/\n4:memory:/docker/abc\n0::/\n"
+	if path := selfCgroupPath(v1, true); path != "/docker/abc" {
+		t.Errorf("expected the v1 memory cgroup path, got %q", path)
+	}
+
+	if path := selfCgroupPath(v1, false); path != "/" {
+		t.Errorf("expected the v2 cgroup path, got %q", path)
+	}
+}
+
+func TestReadMap(t *testing.T) {
+	syncmap := NewReadMap[string, int]()
+	syncmap.Set("a", 1)
+	syncmap.Set("b", 2)
+
+	clone := syncmap.Clone()
+
+	syncmap.Set("a", 3)
+	if val, _ := syncmap.Get("a"); val != 3 {
+		t.Errorf("expected an updated key to be read in place, got %d", val)
+	}
+
+	syncmap.Del("b")
+	syncmap.Set("c", 4)
+	if syncmap.Has("b") || !syncmap.Has("c") || syncmap.Len() != 2 {
+		t.Error("expected added and removed keys to be published to readers")
+	}
+
+	if val, _ := clone.Get("a"); val != 1 || !clone.Has("b") || clone.Has("c") {
+		t.Error("expected a clone to keep its own copy of the map")
+	}
+}
+
+func TestSyncMapWatch(t *testing.T) {
+	syncmap := NewMap[string, int]()
+	watcher := syncmap.WatchAll(WatchOptions{Buffer: 16})
+	defer watcher.Close()
+
+	syncmap.Set("a", 1)
+	syncmap.Del("a")
+	syncmap.Set("b", 2)
+	syncmap.CompareAndSwap("b", 2, 3)
+	syncmap.CompareAndSwap("b", 2, 4)
+	syncmap.CompareAndDelete("b", 3)
+	syncmap.Update("c", func(old int, ok bool) int { return 5 })
+	syncmap.Clear()
+
+	expected := []SyncMapEvent[string, int]{
+		{Op: WATCH_SET, Key: "a", New: 1},
+		{Op: WATCH_DEL, Key: "a", Old: 1, Loaded: true},
+		{Op: WATCH_SET, Key: "b", New: 2},
+		{Op: WATCH_SET, Key: "b", Old: 2, New: 3, Loaded: true},
+		{Op: WATCH_DEL, Key: "b", Old: 3, Loaded: true},
+		{Op: WATCH_SET, Key: "c", New: 5},
+		{Op: WATCH_DEL, Key: "c", Old: 5, Loaded: true},
+	}
+
+	for _, want := range expected {
+		select {
+		case event := <-watcher.Events():
+			if event != want {
+				t.Errorf("expected %+v, got %+v", want, event)
+			}
+		default:
+			t.Fatalf("expected %+v, got no event", want)
+		}
+	}
+
+	select {
+	case event := <-watcher.Events():
+		t.Errorf("expected no more events, got %+v", event)
+	default:
+	}
+}
+
+func TestSyncMapWatchBlock(t *testing.T) {
+	syncmap := NewMap[int, int]()
+	watcher := syncmap.WatchAll(WatchOptions{Buffer: 1, Policy: WATCH_BLOCK})
+	defer watcher.Close()
+
+	// fill the buffer, so the next writers wait for the watcher
+	syncmap.Set(0, 0)
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			syncmap.Set(i, i)
+		}()
+		time.Sleep(time.Millisecond)
+	}
+
+	// the watcher reads the map before it reads its events
+	read := make(chan struct{})
+	go func() {
+		syncmap.Get(0)
+		close(read)
+	}()
+
+	select {
+	case <-read:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a watcher to read the map while writers wait for it")
+	}
+
+	for i := 0; i <= 20; i++ {
+		select {
+		case <-watcher.Events():
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected 21 events, got %d", i)
+		}
+	}
+
+	wg.Wait()
+}
+
+func TestFSWatcherPoll(t *testing.T) {
+	root := t.TempDir()
+
+	fw := FileWatcher()
+	fw.PollInterval = 20 * time.Millisecond
+	fw.Debounce = 50 * time.Millisecond
+	events := fw.Events()
+	defer fw.Close()
+
+	if err := fw.WatchDirPoll(root); err != nil {
+		t.Fatal(err)
+	}
+
+	next := func(kind FSEventKind, path string) FSEvent {
+		t.Helper()
+		select {
+		case event := <-events:
+			if event.Kind != kind || event.Path != path {
+				t.Fatalf("expected %s %s, got %s %s", kind, path, event.Kind, event.Path)
+			}
+			return event
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected %s %s, got no event", kind, path)
+			return FSEvent{}
+		}
+	}
+
+	file := filepath.Join(root, "a.txt")
+	os.WriteFile(file, []byte("a"), 0644)
+	next(FSEVENT_MODIFY, file)
+
+	moved := filepath.Join(root, "b.txt")
+	os.Rename(file, moved)
+	if event := next(FSEVENT_MOVE, moved); event.OldPath != file {
+		t.Errorf("expected move from %s, got %s", file, event.OldPath)
+	}
+
+	dir := filepath.Join(root, "dir")
+	os.Mkdir(dir, 0755)
+	next(FSEVENT_ADD, dir)
+
+	sub := filepath.Join(dir, "c.txt")
+	os.WriteFile(sub, []byte("c"), 0644)
+	next(FSEVENT_MODIFY, sub)
+
+	os.Remove(moved)
+	next(FSEVENT_REMOVE, moved)
+}
+
+func TestFSWatcherMove(t *testing.T) {
+	root := t.TempDir()
+	elsewhere := t.TempDir()
+
+	a := filepath.Join(root, "a.txt")
+	b := filepath.Join(root, "b.txt")
+	c := filepath.Join(root, "c.txt")
+	os.WriteFile(a, []byte("a"), 0644)
+	os.WriteFile(c, []byte("c"), 0644)
+
+	fw := FileWatcher()
+	fw.Debounce = 100 * time.Millisecond
+	batches := make(chan []FSEvent, 8)
+	fw.OnBatch = func(events []FSEvent) { batches <- events }
+	defer fw.Close()
+
+	if err := fw.WatchDir(root); err != nil {
+		t.Fatal(err)
+	}
+
+	next := func() map[string]FSEvent {
+		t.Helper()
+		select {
+		case events := <-batches:
+			byPath := map[string]FSEvent{}
+			for _, event := range events {
+				byPath[event.Path] = event
+			}
+			return byPath
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected a batch of events, got none")
+			return nil
+		}
+	}
+
+	// a file moved out of the root is not paired with a new file
+	os.Rename(a, filepath.Join(elsewhere, "a.txt"))
+	os.WriteFile(b, []byte("b"), 0644)
+
+	events := next()
+	if event := events[a]; event.Kind != FSEVENT_REMOVE {
+		t.Errorf("expected remove %s, got %+v", a, event)
+	}
+	if event := events[b]; event.Kind != FSEVENT_MODIFY || event.OldPath != "" {
+		t.Errorf("expected modify %s, got %+v", b, event)
+	}
+
+	// a destination with an earlier event in the window is still paired
+	os.WriteFile(b, []byte("bb"), 0644)
+	os.Rename(c, b)
+
+	events = next()
+	if event := events[b]; event.Kind != FSEVENT_MOVE || event.OldPath != c || !event.Op.Has(FSOP_RENAME|FSOP_WRITE) {
+		t.Errorf("expected move %s to %s, got %+v", c, b, event)
+	}
+	if event, ok := events[c]; ok {
+		t.Errorf("expected no event for %s, got %+v", c, event)
+	}
+}
+
+func TestFSWatcherNewDir(t *testing.T) {
+	t.Run("inotify", func(t *testing.T) { testFSWatcherNewDir(t, false) })
+	t.Run("poll", func(t *testing.T) { testFSWatcherNewDir(t, true) })
+}
+
+func testFSWatcherNewDir(t *testing.T, poll bool) {
+	root := t.TempDir()
+
+	fw := FileWatcher()
+	fw.Debounce = 50 * time.Millisecond
+	fw.PollInterval = 20 * time.Millisecond
+	events := fw.Events()
+	defer fw.Close()
+
+	watch := fw.WatchDir
+	if poll {
+		watch = fw.WatchDirPoll
+	}
+	if err := watch(root); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]FSEventKind{}
+	wait := func(path string) {
+		t.Helper()
+		for {
+			if _, ok := seen[path]; ok {
+				return
+			}
+
+			select {
+			case event := <-events:
+				seen[event.Path] = event.Kind
+			case <-time.After(2 * time.Second):
+				t.Fatalf("expected an event for %s, got %v", path, seen)
+			}
+		}
+	}
+
+	// the subtree is created before the new directory can be watched
+	sub := filepath.Join(root, "n", "m")
+	os.MkdirAll(sub, 0755)
+	os.WriteFile(filepath.Join(sub, "q.txt"), []byte("q"), 0644)
+
+	wait(filepath.Join(root, "n"))
+	wait(sub)
+	wait(filepath.Join(sub, "q.txt"))
+
+	if kind := seen[sub]; kind != FSEVENT_ADD {
+		t.Errorf("expected add %s, got %s", sub, kind)
+	}
+
+	// the new subdirectory is watched
+	os.WriteFile(filepath.Join(sub, "r.txt"), []byte("r"), 0644)
+	wait(filepath.Join(sub, "r.txt"))
+}
+
+func TestFSWatcherFiles(t *testing.T) {
+	root := t.TempDir()
+	a := filepath.Join(root, "a.conf")
+	b := filepath.Join(root, "b.conf")
+	c := filepath.Join(root, "c.conf")
+	os.WriteFile(c, []byte("c"), 0644)
+
+	fw := FileWatcher()
+	fw.Debounce = 20 * time.Millisecond
+	events := fw.Events()
+	defer fw.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := fw.WatchPaths(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.WatchFileContext(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	fw.mu.Lock()
+	shared := len(fw.fileDirs) == 1 && (*fw.watcherList)[a] == (*fw.watcherList)[b] && (*fw.watcherList)[a] == (*fw.watcherList)[c]
+	fw.mu.Unlock()
+	if !shared {
+		t.Fatal("expected files in the same directory to share one watcher")
+	}
+
+	next := func(path string) {
+		t.Helper()
+		select {
+		case event := <-events:
+			if event.Path != path || event.Root != path {
+				t.Fatalf("expected an event for %s, got %+v", path, event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected an event for %s, got none", path)
+		}
+	}
+
+	os.WriteFile(a, []byte("a"), 0644)
+	next(a)
+
+	fw.CloseWatcher(a)
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+
+	os.WriteFile(a, []byte("aa"), 0644)
+	os.WriteFile(c, []byte("cc"), 0644)
+	os.WriteFile(b, []byte("b"), 0644)
+	next(b)
+
+	fw.CloseWatcher(b)
+
+	done := make(chan struct{})
+	go func() {
+		fw.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the watcher to stop after its last file was closed")
+	}
+}
+
+// failBackend is an fsBackend that cannot watch any directory
+type failBackend struct{}
+
+func (failBackend) Add(path string) error            { return os.ErrPermission }
+func (failBackend) Remove(path string) error         { return nil }
+func (failBackend) Close() error                     { return nil }
+func (failBackend) events() <-chan fsnotify.Event    { return nil }
+func (failBackend) errors() <-chan error             { return nil }
+func (failBackend) stats() (watched int, polled int) { return 0, 0 }
+
+func TestFSWatcherSetupErrors(t *testing.T) {
+	root := t.TempDir()
+	for i := 0; i < 20; i++ {
+		os.Mkdir(filepath.Join(root, strconv.Itoa(i)), 0755)
+	}
+
+	fw := FileWatcher()
+	errs := fw.Errors()
+
+	// the errors are not read until the setup is done
+	done := make(chan struct{})
+	go func() {
+		fw.watchDirSub(failBackend{}, fw.newFilter(root), root, fw.trySendError)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the setup to not wait for the errors to be read")
+	}
+
+	if received, dropped := len(errs), fw.DroppedErrors(); received+int(dropped) != 20 || dropped == 0 {
+		t.Errorf("expected 20 errors to be sent or dropped, got %d sent and %d dropped", received, dropped)
+	}
+}
+
+func TestFSWatcherFilter(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "sub"), 0755)
+	os.WriteFile(filepath.Join(root, ".gitignore"), []byte("# comment\n*.log\n!keep.log\nbuild/\n/top.go\ndocs/**/*.tmp\n"), 0644)
+	os.WriteFile(filepath.Join(root, "sub", ".gitignore"), []byte("!debug.log\nlocal/\n"), 0644)
+
+	fw := FileWatcher()
+	fw.GitIgnore = true
+	fw.Include = []string{"*.go", "*.log", "docs/**"}
+
+	filter := fw.newFilter(root)
+	filter.loadGitIgnore(root)
+	filter.loadGitIgnore(filepath.Join(root, "sub"))
+
+	tests := []struct {
+		path  string
+		isDir bool
+		skip  bool
+		emit  bool
+	}{
+		{"a.go", false, false, true},
+		// not included
+		{"a.txt", false, false, false},
+		{"app.log", false, true, false},
+		// negated
+		{"keep.log", false, false, true},
+		// negated by a deeper .gitignore
+		{"sub/debug.log", false, false, true},
+		{"sub/other.log", false, true, false},
+		// directory only
+		{"build", true, true, false},
+		{"build", false, false, false},
+		{"build/x.go", false, true, false},
+		{"sub/build/x.go", false, true, false},
+		// anchored
+		{"top.go", false, true, false},
+		{"sub/top.go", false, false, true},
+		// "**" matches any number of directories
+		{"docs/c.tmp", false, true, false},
+		{"docs/a/b/c.tmp", false, true, false},
+		{"docs/a/readme.md", false, false, true},
+		// rules only apply below their .gitignore
+		{"sub/local", true, true, false},
+		{"local", true, false, true},
+		// default excludes
+		{".git", true, true, false},
+		{"node_modules/x.go", false, true, false},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(root, filepath.FromSlash(test.path))
+		if skip := filter.skip(path, test.isDir); skip != test.skip {
+			t.Errorf("%s (dir: %v): expected skip %v, got %v", test.path, test.isDir, test.skip, skip)
+		}
+		if emit := filter.emit(path, test.isDir); emit != test.emit {
+			t.Errorf("%s (dir: %v): expected emit %v, got %v", test.path, test.isDir, test.emit, emit)
+		}
+	}
+}
+
+func BenchmarkCacheMap(b *testing.B) {
+	cache := NewCache[string, int](time.Hour)
+	benchParallel(b, func(key string) { cache.Get(key) }, func(key string, value int) { cache.Set(key, value, nil) })
+}
+
+func BenchmarkShardedCache(b *testing.B) {
+	cache := NewShardedCache[string, int](0, time.Hour)
+	benchParallel(b, func(key string) { cache.Get(key) }, func(key string, value int) { cache.Set(key, value, nil) })
+}
+
+// BenchmarkMutexMap is a plain map with a sync.Mutex, to compare SyncMap against
+func BenchmarkMutexMap(b *testing.B) {
+	value := map[string]int{}
+	var mu sync.Mutex
+	benchParallel(b, func(key string) {
+		mu.Lock()
+		_ = value[key]
+		mu.Unlock()
+	}, func(key string, val int) {
+		mu.Lock()
+		value[key] = val
+		mu.Unlock()
+	})
+}
+
+func BenchmarkSyncMap(b *testing.B) {
+	syncmap := NewMap[string, int]()
+	benchParallel(b, func(key string) { syncmap.Get(key) }, syncmap.Set)
+}
+
+func BenchmarkReadMap(b *testing.B) {
+	syncmap := NewReadMap[string, int]()
+	benchParallel(b, func(key string) { syncmap.Get(key) }, syncmap.Set)
+}
+
+// benchParallel runs a parallel workload of 90% reads and 10% writes
+func benchParallel(b *testing.B, get func(key string), set func(key string, value int)) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		set(keys[i], i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := keys[i%len(keys)]
+			if i%10 == 0 {
+				set(key, i)
+			} else {
+				get(key)
+			}
+			i++
+		}
+	})
+}
@@ -0,0 +1,497 @@
+package goutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// cacheMarshalItem is the json and yaml form of a cache item, when `CacheOptions.MarshalMeta` is enabled
+type cacheMarshalItem[V any] struct {
+	Value   V             `json:"value" yaml:"value"`
+	Err     string        `json:"error,omitempty" yaml:"error,omitempty"`
+	LastUse time.Time     `json:"lastUse" yaml:"lastUse"`
+	Created time.Time     `json:"created" yaml:"created"`
+	Updated time.Time     `json:"updated" yaml:"updated"`
+	TTL     time.Duration `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	ExpAt   *time.Time    `json:"expAt,omitempty" yaml:"expAt,omitempty"`
+	Tags    []string      `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+// MarshalJSON encodes a snapshot of the map as a json object
+//
+// keys are sorted, or kept in insertion order for maps made with `NewOrderedMap`
+func (syncmap *SyncMap[K, V]) MarshalJSON() ([]byte, error) {
+	keys, values := syncmap.snapshot()
+	return marshalJsonMap(keys, values)
+}
+
+// UnmarshalJSON replaces the contents of the map with a json object
+func (syncmap *SyncMap[K, V]) UnmarshalJSON(b []byte) error {
+	keys, values, err := unmarshalJsonMap[K, V](b)
+	if err != nil {
+		return err
+	}
+
+	syncmap.replace(keys, values)
+	return nil
+}
+
+// MarshalYAML encodes a snapshot of the map as a yaml mapping
+//
+// keys are sorted, or kept in insertion order for maps made with `NewOrderedMap`
+func (syncmap *SyncMap[K, V]) MarshalYAML() (interface{}, error) {
+	keys, values := syncmap.snapshot()
+	return marshalYamlMap(keys, values)
+}
+
+// UnmarshalYAML replaces the contents of the map with a yaml mapping
+func (syncmap *SyncMap[K, V]) UnmarshalYAML(node *yaml.Node) error {
+	keys, values, err := unmarshalYamlMap[K, V](node)
+	if err != nil {
+		return err
+	}
+
+	syncmap.replace(keys, values)
+	return nil
+}
+
+// snapshot returns a consistent list of keys and values in the map
+func (syncmap *SyncMap[K, V]) snapshot() ([]K, []V) {
+	value, unlock := syncmap.view()
+	defer unlock()
+
+	var keys []K
+	if syncmap.ordered {
+		keys = slices.Clone(syncmap.order)
+	} else {
+		keys = make([]K, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sortMapKeys(keys)
+	}
+
+	values := make([]V, len(keys))
+	for i, key := range keys {
+		values[i] = value[key]
+	}
+
+	return keys, values
+}
+
+// replace replaces the contents of the map with a list of keys and values
+func (syncmap *SyncMap[K, V]) replace(keys []K, values []V) {
+	syncmap.lock()
+	defer syncmap.unlock()
+
+	keep := make(map[K]struct{}, len(keys))
+	for _, key := range keys {
+		keep[key] = struct{}{}
+	}
+
+	for key := range syncmap.value {
+		if _, ok := keep[key]; !ok {
+			syncmap.remove(key)
+		}
+	}
+
+	for i, key := range keys {
+		syncmap.store(key, values[i])
+	}
+}
+
+// MarshalJSON encodes a snapshot of the cache as a json object
+//
+// by default, only values are encoded (errors and expired items are skipped)
+//
+// with `CacheOptions.MarshalMeta`, each item is encoded with its error and expiration state
+func (cache *CacheMap[K, V]) MarshalJSON() ([]byte, error) {
+	keys, values := cache.marshalItems()
+	return marshalJsonMap(keys, values)
+}
+
+// UnmarshalJSON adds the items of a json object to the cache
+//
+// the format must match the `CacheOptions.MarshalMeta` setting of the cache
+func (cache *CacheMap[K, V]) UnmarshalJSON(b []byte) error {
+	if cache.marshalMeta {
+		keys, items, err := unmarshalJsonMap[K, cacheMarshalItem[V]](b)
+		if err != nil {
+			return err
+		}
+		cache.unmarshalItems(keys, nil, items)
+		return nil
+	}
+
+	keys, values, err := unmarshalJsonMap[K, V](b)
+	if err != nil {
+		return err
+	}
+	cache.unmarshalItems(keys, values, nil)
+	return nil
+}
+
+// MarshalYAML encodes a snapshot of the cache as a yaml mapping
+//
+// by default, only values are encoded (errors and expired items are skipped)
+//
+// with `CacheOptions.MarshalMeta`, each item is encoded with its error and expiration state
+func (cache *CacheMap[K, V]) MarshalYAML() (interface{}, error) {
+	keys, values := cache.marshalItems()
+	return marshalYamlMap(keys, values)
+}
+
+// UnmarshalYAML adds the items of a yaml mapping to the cache
+//
+// the format must match the `CacheOptions.MarshalMeta` setting of the cache
+func (cache *CacheMap[K, V]) UnmarshalYAML(node *yaml.Node) error {
+	if cache.marshalMeta {
+		keys, items, err := unmarshalYamlMap[K, cacheMarshalItem[V]](node)
+		if err != nil {
+			return err
+		}
+		cache.unmarshalItems(keys, nil, items)
+		return nil
+	}
+
+	keys, values, err := unmarshalYamlMap[K, V](node)
+	if err != nil {
+		return err
+	}
+	cache.unmarshalItems(keys, values, nil)
+	return nil
+}
+
+// marshalItems returns a consistent list of keys, and their values or items (with MarshalMeta)
+func (cache *CacheMap[K, V]) marshalItems() ([]K, []any) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	now := time.Now()
+	keys := make([]K, 0, len(cache.entry))
+	for key := range cache.entry {
+		if cache.expired(key, now) {
+			continue
+		}
+
+		if _, ok := cache.err[key]; ok && !cache.marshalMeta {
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+	sortMapKeys(keys)
+
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		if !cache.marshalMeta {
+			values[i] = cache.value[key]
+			continue
+		}
+
+		item := cacheMarshalItem[V]{
+			Value:   cache.value[key],
+			LastUse: cache.lastUse(key),
+			Created: cache.entry[key].created,
+			Updated: cache.updated[key],
+			TTL:     cache.ttl[key],
+			Tags:    cache.tags[key],
+		}
+
+		if err, ok := cache.err[key]; ok {
+			item.Err = err.Error()
+		}
+
+		if expAt, ok := cache.expAt[key]; ok {
+			item.ExpAt = &expAt
+		}
+
+		values[i] = item
+	}
+
+	return keys, values
+}
+
+// unmarshalItems adds decoded values, or items (with MarshalMeta), to the cache
+func (cache *CacheMap[K, V]) unmarshalItems(keys []K, values []V, items []cacheMarshalItem[V]) {
+	cache.mu.Lock()
+	defer cache.unlock()
+
+	if cache.value == nil {
+		cache.initMaps()
+	}
+
+	now := time.Now()
+	for i, key := range keys {
+		if items == nil {
+			cache.set(key, values[i], nil)
+			continue
+		}
+
+		item := cacheSnapshotItem[K, V]{
+			Key:     key,
+			Value:   items[i].Value,
+			Err:     items[i].Err,
+			IsErr:   items[i].Err != "",
+			LastUse: items[i].LastUse,
+			Created: items[i].Created,
+			Updated: items[i].Updated,
+			TTL:     items[i].TTL,
+			Tags:    items[i].Tags,
+		}
+
+		if items[i].ExpAt != nil {
+			item.ExpAt = *items[i].ExpAt
+		}
+
+		cache.restoreItem(item, now)
+	}
+}
+
+// marshalJsonMap encodes a list of keys and values as a json object, in the same order
+func marshalJsonMap[K Hashable, V any](keys []K, values []V) ([]byte, error) {
+	buf := bytes.Buffer{}
+	buf.WriteByte('{')
+
+	for i, key := range keys {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+
+		k, err := json.Marshal(formatMapKey(key))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+
+		v, err := json.Marshal(values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// unmarshalJsonMap decodes a json object into a list of keys and values, in the same order
+func unmarshalJsonMap[K Hashable, V any](b []byte) ([]K, []V, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	if tok == nil {
+		return nil, nil, nil
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, nil, errors.New("json value is not an object")
+	}
+
+	keys := []K{}
+	values := []V{}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		key, err := parseMapKey[K](tok.(string))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var val V
+		if err := dec.Decode(&val); err != nil {
+			return nil, nil, err
+		}
+
+		keys = append(keys, key)
+		values = append(values, val)
+	}
+
+	return keys, values, nil
+}
+
+// marshalYamlMap encodes a list of keys and values as a yaml mapping, in the same order
+func marshalYamlMap[K Hashable, V any](keys []K, values []V) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+
+	for i, key := range keys {
+		keyNode := &yaml.Node{}
+		if err := keyNode.Encode(key); err != nil {
+			return nil, err
+		}
+
+		valNode := &yaml.Node{}
+		if err := valNode.Encode(values[i]); err != nil {
+			return nil, err
+		}
+
+		node.Content = append(node.Content, keyNode, valNode)
+	}
+
+	return node, nil
+}
+
+// unmarshalYamlMap decodes a yaml mapping into a list of keys and values, in the same order
+func unmarshalYamlMap[K Hashable, V any](node *yaml.Node) ([]K, []V, error) {
+	if node.Kind == yaml.DocumentNode && len(node.Content) != 0 {
+		node = node.Content[0]
+	}
+
+	if node.Tag == "!!null" {
+		return nil, nil, nil
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return nil, nil, errors.New("yaml value is not a mapping")
+	}
+
+	keys := make([]K, 0, len(node.Content)/2)
+	values := make([]V, 0, len(node.Content)/2)
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		var key K
+		if err := node.Content[i].Decode(&key); err != nil {
+			return nil, nil, err
+		}
+
+		var val V
+		if err := node.Content[i+1].Decode(&val); err != nil {
+			return nil, nil, err
+		}
+
+		keys = append(keys, key)
+		values = append(values, val)
+	}
+
+	return keys, values, nil
+}
+
+// sortMapKeys sorts a list of keys by their string form, like encoding/json does for maps
+func sortMapKeys[K Hashable](keys []K) {
+	slices.SortFunc(keys, func(a, b K) int {
+		return strings.Compare(formatMapKey(a), formatMapKey(b))
+	})
+}
+
+// formatMapKey converts a map key to a string, for formats that only allow string keys
+func formatMapKey[K Hashable](key K) string {
+	switch k := any(key).(type) {
+	case string:
+		return k
+	case int:
+		return strconv.FormatInt(int64(k), 10)
+	case int8:
+		return strconv.FormatInt(int64(k), 10)
+	case int16:
+		return strconv.FormatInt(int64(k), 10)
+	case int32:
+		return strconv.FormatInt(int64(k), 10)
+	case int64:
+		return strconv.FormatInt(k, 10)
+	case uint:
+		return strconv.FormatUint(uint64(k), 10)
+	case uint8:
+		return strconv.FormatUint(uint64(k), 10)
+	case uint16:
+		return strconv.FormatUint(uint64(k), 10)
+	case uint32:
+		return strconv.FormatUint(uint64(k), 10)
+	case uint64:
+		return strconv.FormatUint(k, 10)
+	case uintptr:
+		return strconv.FormatUint(uint64(k), 10)
+	case float32:
+		return strconv.FormatFloat(float64(k), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(k, 'g', -1, 64)
+	case complex64:
+		return strconv.FormatComplex(complex128(k), 'g', -1, 64)
+	case complex128:
+		return strconv.FormatComplex(k, 'g', -1, 128)
+	default:
+		return ""
+	}
+}
+
+// parseMapKey converts a string from `formatMapKey` back into a map key
+func parseMapKey[K Hashable](str string) (K, error) {
+	var key K
+	var val any
+	var err error
+
+	switch any(key).(type) {
+	case string:
+		val = str
+	case int:
+		var n int64
+		n, err = strconv.ParseInt(str, 10, strconv.IntSize)
+		val = int(n)
+	case int8:
+		var n int64
+		n, err = strconv.ParseInt(str, 10, 8)
+		val = int8(n)
+	case int16:
+		var n int64
+		n, err = strconv.ParseInt(str, 10, 16)
+		val = int16(n)
+	case int32:
+		var n int64
+		n, err = strconv.ParseInt(str, 10, 32)
+		val = int32(n)
+	case int64:
+		val, err = strconv.ParseInt(str, 10, 64)
+	case uint:
+		var n uint64
+		n, err = strconv.ParseUint(str, 10, strconv.IntSize)
+		val = uint(n)
+	case uint8:
+		var n uint64
+		n, err = strconv.ParseUint(str, 10, 8)
+		val = uint8(n)
+	case uint16:
+		var n uint64
+		n, err = strconv.ParseUint(str, 10, 16)
+		val = uint16(n)
+	case uint32:
+		var n uint64
+		n, err = strconv.ParseUint(str, 10, 32)
+		val = uint32(n)
+	case uint64:
+		val, err = strconv.ParseUint(str, 10, 64)
+	case uintptr:
+		var n uint64
+		n, err = strconv.ParseUint(str, 10, 64)
+		val = uintptr(n)
+	case float32:
+		var n float64
+		n, err = strconv.ParseFloat(str, 32)
+		val = float32(n)
+	case float64:
+		val, err = strconv.ParseFloat(str, 64)
+	case complex64:
+		var n complex128
+		n, err = strconv.ParseComplex(str, 64)
+		val = complex64(n)
+	case complex128:
+		val, err = strconv.ParseComplex(str, 128)
+	}
+
+	if err != nil {
+		return key, err
+	}
+	return val.(K), nil
+}
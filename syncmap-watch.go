@@ -0,0 +1,195 @@
+package goutil
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// WatchOp is the kind of change a `SyncMapEvent` reports
+type WatchOp uint8
+
+const (
+	// WATCH_SET means a key was added or changed
+	WATCH_SET WatchOp = iota
+
+	// WATCH_DEL means a key was removed
+	WATCH_DEL
+)
+
+// WatchPolicy decides what happens when a watcher's buffer is full
+type WatchPolicy uint8
+
+const (
+	// WATCH_DROP drops the new event (default)
+	WATCH_DROP WatchPolicy = iota
+
+	// WATCH_DROP_OLDEST drops the oldest buffered event, to make room for the new one
+	WATCH_DROP_OLDEST
+
+	// WATCH_BLOCK makes writers wait until the watcher has room for the event
+	//
+	// note: do not write to the map from the goroutine that reads the events,
+	// or it may wait on itself forever
+	WATCH_BLOCK
+)
+
+// SyncMapEvent is a change to a `SyncMap`
+type SyncMapEvent[K Hashable, V any] struct {
+	Op  WatchOp
+	Key K
+
+	// Old is the previous value of the key (if Loaded is true)
+	Old V
+
+	// New is the new value of the key (for WATCH_SET)
+	New V
+
+	// Loaded is true if the key existed before the change
+	Loaded bool
+}
+
+// WatchOptions are optional settings for the `Watch` and `WatchAll` methods
+type WatchOptions struct {
+	// Buffer is the number of events that can wait to be read
+	//
+	// default: 16
+	Buffer int
+
+	// Policy decides what happens when the buffer is full
+	//
+	// default: WATCH_DROP
+	Policy WatchPolicy
+}
+
+// SyncMapWatcher receives changes to a `SyncMap`
+type SyncMapWatcher[K Hashable, V any] struct {
+	syncmap *SyncMap[K, V]
+	key     K
+	all     bool
+	policy  WatchPolicy
+	ch      chan SyncMapEvent[K, V]
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+// Watch returns a watcher that receives every set and delete of a key
+func (syncmap *SyncMap[K, V]) Watch(key K, opts ...WatchOptions) *SyncMapWatcher[K, V] {
+	watcher := newSyncMapWatcher(syncmap, opts...)
+	watcher.key = key
+	syncmap.addWatcher(watcher)
+	return watcher
+}
+
+// WatchAll returns a watcher that receives every set and delete in the map
+func (syncmap *SyncMap[K, V]) WatchAll(opts ...WatchOptions) *SyncMapWatcher[K, V] {
+	watcher := newSyncMapWatcher(syncmap, opts...)
+	watcher.all = true
+	syncmap.addWatcher(watcher)
+	return watcher
+}
+
+// Events returns the channel that changes are sent to
+//
+// the channel is closed when the watcher is closed
+func (watcher *SyncMapWatcher[K, V]) Events() <-chan SyncMapEvent[K, V] {
+	return watcher.ch
+}
+
+// Dropped returns the number of events that were dropped because the buffer was full
+func (watcher *SyncMapWatcher[K, V]) Dropped() uint64 {
+	return watcher.dropped.Load()
+}
+
+// Close unsubscribes the watcher from the map, and closes its channel
+//
+// it is safe to call this method more than once
+func (watcher *SyncMapWatcher[K, V]) Close() {
+	watcher.once.Do(func() {
+		// wake any writer waiting on this watcher, before waiting for the lock it holds
+		close(watcher.done)
+
+		syncmap := watcher.syncmap
+		syncmap.watchMu.Lock()
+		defer syncmap.watchMu.Unlock()
+
+		if i := slices.Index(syncmap.watchers, watcher); i != -1 {
+			syncmap.watchers = slices.Delete(syncmap.watchers, i, i+1)
+			syncmap.watching.Add(-1)
+		}
+
+		close(watcher.ch)
+	})
+}
+
+func newSyncMapWatcher[K Hashable, V any](syncmap *SyncMap[K, V], opts ...WatchOptions) *SyncMapWatcher[K, V] {
+	buffer := 16
+	policy := WATCH_DROP
+	if len(opts) != 0 {
+		if opts[0].Buffer > 0 {
+			buffer = opts[0].Buffer
+		}
+		policy = opts[0].Policy
+	}
+
+	return &SyncMapWatcher[K, V]{
+		syncmap: syncmap,
+		policy:  policy,
+		ch:      make(chan SyncMapEvent[K, V], buffer),
+		done:    make(chan struct{}),
+	}
+}
+
+// addWatcher subscribes a watcher to the map
+func (syncmap *SyncMap[K, V]) addWatcher(watcher *SyncMapWatcher[K, V]) {
+	syncmap.watchMu.Lock()
+	defer syncmap.watchMu.Unlock()
+
+	syncmap.watchers = append(syncmap.watchers, watcher)
+	syncmap.watching.Add(1)
+}
+
+// notify sends events to every watcher they match
+//
+// note: watchMu must already be locked
+func (syncmap *SyncMap[K, V]) notify(events []SyncMapEvent[K, V]) {
+	for _, event := range events {
+		for _, watcher := range syncmap.watchers {
+			if watcher.all || watcher.key == event.Key {
+				watcher.send(event)
+			}
+		}
+	}
+}
+
+// send sends an event to the watcher, following its buffer policy
+func (watcher *SyncMapWatcher[K, V]) send(event SyncMapEvent[K, V]) {
+	switch watcher.policy {
+	case WATCH_BLOCK:
+		select {
+		case watcher.ch <- event:
+		case <-watcher.done:
+		}
+	case WATCH_DROP_OLDEST:
+		for {
+			select {
+			case watcher.ch <- event:
+				return
+			default:
+			}
+
+			select {
+			case <-watcher.ch:
+				watcher.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case watcher.ch <- event:
+		default:
+			watcher.dropped.Add(1)
+		}
+	}
+}
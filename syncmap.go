@@ -1,98 +1,444 @@
 package goutil
 
-import "sync"
+import (
+	"iter"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
 
 type SyncMap[K Hashable, V any] struct {
 	value map[K]V
-	hasVal map[K]bool
-	mu sync.Mutex
-	null V
+	mu    sync.RWMutex
+	null  V
+
+	// cow (copy-on-write) keeps a read only copy of the map, that reads can load without locking
+	//
+	// values of existing keys are updated in place, so only adding or removing keys makes a new copy
+	cow   bool
+	read  atomic.Pointer[map[K]*syncMapEntry[V]]
+	dirty bool
+
+	// ordered keeps a list of keys in insertion order, for deterministic iteration
+	ordered bool
+	order   []K
+
+	watchers []*SyncMapWatcher[K, V]
+	watchMu  sync.Mutex
+	watching atomic.Int32
+	events   []SyncMapEvent[K, V]
+
+	// queue holds the events that were made, but not yet sent to watchers
+	queue   []SyncMapEvent[K, V]
+	queueMu sync.Mutex
+}
+
+// syncMapEntry is a value in the read only copy of a copy-on-write map
+type syncMapEntry[V any] struct {
+	value atomic.Pointer[V]
 }
 
-// NewMap creates a new synchronized map that uses sync.Mutex behind the scenes
+// NewMap creates a new synchronized map that uses sync.RWMutex behind the scenes
 func NewMap[K Hashable, V any]() *SyncMap[K, V] {
 	return &SyncMap[K, V]{
 		value: map[K]V{},
-		hasVal: map[K]bool{},
+	}
+}
+
+// NewReadMap creates a new synchronized map that is optimized for reads
+//
+// reads never lock, and setting a key that already exists updates it in place,
+// but adding or removing a key makes a new copy of the map
+//
+// this is best for maps with a stable set of keys, that are read often.
+// for maps that keep adding and removing keys, use `NewMap` instead
+func NewReadMap[K Hashable, V any]() *SyncMap[K, V] {
+	syncmap := &SyncMap[K, V]{
+		value: map[K]V{},
+		cow:   true,
+	}
+	syncmap.publish()
+	return syncmap
+}
+
+// NewOrderedMap creates a new synchronized map that iterates in insertion order
+//
+// this makes `ForEach`, `All`, `Keys`, and `Values` deterministic,
+// at the cost of slower deletes
+func NewOrderedMap[K Hashable, V any]() *SyncMap[K, V] {
+	return &SyncMap[K, V]{
+		value:   map[K]V{},
+		ordered: true,
 	}
 }
 
 // Get returns a value or an error if it exists
 func (syncmap *SyncMap[K, V]) Get(key K) (V, bool) {
-	syncmap.mu.Lock()
-	defer syncmap.mu.Unlock()
-
-	if hasVal, ok := syncmap.hasVal[key]; !ok || !hasVal {
+	if syncmap.cow {
+		if entry, ok := (*syncmap.read.Load())[key]; ok {
+			return *entry.value.Load(), true
+		}
 		return syncmap.null, false
-	}else if val, ok := syncmap.value[key]; ok {
-		return val, true
 	}
 
-	return syncmap.null, false
+	syncmap.mu.RLock()
+	defer syncmap.mu.RUnlock()
+
+	val, ok := syncmap.value[key]
+	return val, ok
 }
 
 // Set sets or adds a new key with a value
 func (syncmap *SyncMap[K, V]) Set(key K, value V) {
-	syncmap.mu.Lock()
-	defer syncmap.mu.Unlock()
+	syncmap.lock()
+	defer syncmap.unlock()
 
-	syncmap.value[key] = value
-	syncmap.hasVal[key] = true
+	syncmap.store(key, value)
 }
 
 // Del removes an item by key
-func (syncmap *SyncMap[K, V]) Del(key K){
-	syncmap.mu.Lock()
-	defer syncmap.mu.Unlock()
+func (syncmap *SyncMap[K, V]) Del(key K) {
+	syncmap.lock()
+	defer syncmap.unlock()
 
-	delete(syncmap.value, key)
-	delete(syncmap.hasVal, key)
+	syncmap.remove(key)
 }
 
 // Has returns true if a key value exists in the list
 func (syncmap *SyncMap[K, V]) Has(key K) bool {
-	syncmap.mu.Lock()
-	defer syncmap.mu.Unlock()
+	_, ok := syncmap.Get(key)
+	return ok
+}
+
+// ForEach runs a callback function for each key value pair
+//
+// in the callback, return true to continue, and false to break the loop
+func (syncmap *SyncMap[K, V]) ForEach(cb func(key K, value V) bool) {
+	if syncmap.cow {
+		for key, entry := range *syncmap.read.Load() {
+			if !cb(key, *entry.value.Load()) {
+				break
+			}
+		}
+		return
+	}
+
+	syncmap.mu.RLock()
+	var keyList []K
+	if syncmap.ordered {
+		keyList = slices.Clone(syncmap.order)
+	} else {
+		keyList = make([]K, 0, len(syncmap.value))
+		for key := range syncmap.value {
+			keyList = append(keyList, key)
+		}
+	}
+	syncmap.mu.RUnlock()
 
-	if hasVal, ok := syncmap.hasVal[key]; !ok || !hasVal {
+	for _, key := range keyList {
+		val, ok := syncmap.Get(key)
+		if !ok {
+			// removed since the key list was made
+			continue
+		}
+
+		if !cb(key, val) {
+			break
+		}
+	}
+}
+
+// LoadOrStore returns the existing value for a key if it exists,
+// otherwise it stores and returns the given value
+//
+// @loaded: true if the value was loaded, false if it was stored
+func (syncmap *SyncMap[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	if val, ok := syncmap.Get(key); ok {
+		return val, true
+	}
+
+	syncmap.lock()
+	defer syncmap.unlock()
+
+	if val, ok := syncmap.value[key]; ok {
+		return val, true
+	}
+
+	syncmap.store(key, value)
+	return value, false
+}
+
+// LoadAndDelete removes an item by key, and returns its previous value if it existed
+func (syncmap *SyncMap[K, V]) LoadAndDelete(key K) (value V, loaded bool) {
+	syncmap.lock()
+	defer syncmap.unlock()
+
+	value, loaded = syncmap.value[key]
+	syncmap.remove(key)
+	return value, loaded
+}
+
+// Swap sets a new value for a key, and returns its previous value if it existed
+func (syncmap *SyncMap[K, V]) Swap(key K, value V) (previous V, loaded bool) {
+	syncmap.lock()
+	defer syncmap.unlock()
+
+	previous, loaded = syncmap.value[key]
+	syncmap.store(key, value)
+	return previous, loaded
+}
+
+// CompareAndSwap sets a new value for a key, only if its current value is equal to old
+//
+// note: like sync.Map, this method will panic if V is not a comparable type
+func (syncmap *SyncMap[K, V]) CompareAndSwap(key K, old V, new V) (swapped bool) {
+	syncmap.lock()
+	defer syncmap.unlock()
+
+	if val, ok := syncmap.value[key]; !ok || any(val) != any(old) {
 		return false
-	}else if _, ok := syncmap.value[key]; ok {
-		return true
 	}
 
-	return false
+	syncmap.store(key, new)
+	return true
 }
 
-// ForEach runs a callback function for each key value pair
+// CompareAndDelete removes an item by key, only if its current value is equal to old
 //
-// in the callback, return true to continue, and false to break the loop
-func (syncmap *SyncMap[K, V]) ForEach(cb func(key K, value V) bool){
+// note: like sync.Map, this method will panic if V is not a comparable type
+func (syncmap *SyncMap[K, V]) CompareAndDelete(key K, old V) (deleted bool) {
+	syncmap.lock()
+	defer syncmap.unlock()
+
+	if val, ok := syncmap.value[key]; !ok || any(val) != any(old) {
+		return false
+	}
+
+	syncmap.remove(key)
+	return true
+}
+
+// Update sets a new value for a key, from the result of a callback function
+//
+// the callback runs while the map is locked, so it must not use the map
+//
+// @old: the current value of the key
+//
+// @ok: true if the key exists
+func (syncmap *SyncMap[K, V]) Update(key K, cb func(old V, ok bool) V) V {
+	syncmap.lock()
+	defer syncmap.unlock()
+
+	old, ok := syncmap.value[key]
+	value := cb(old, ok)
+	syncmap.store(key, value)
+	return value
+}
+
+// Len returns the number of items in the map
+func (syncmap *SyncMap[K, V]) Len() int {
+	if syncmap.cow {
+		return len(*syncmap.read.Load())
+	}
+
+	syncmap.mu.RLock()
+	defer syncmap.mu.RUnlock()
+
+	return len(syncmap.value)
+}
+
+// All returns an iterator over each key value pair
+//
+// like `ForEach`, the map is not locked while the loop body runs
+func (syncmap *SyncMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(key K, value V) bool) {
+		syncmap.ForEach(yield)
+	}
+}
+
+// Keys returns an iterator over each key
+//
+// use `slices.Collect(syncmap.Keys())` to get a list of keys
+func (syncmap *SyncMap[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(key K) bool) {
+		syncmap.ForEach(func(key K, value V) bool {
+			return yield(key)
+		})
+	}
+}
+
+// Values returns an iterator over each value
+//
+// use `slices.Collect(syncmap.Values())` to get a list of values
+func (syncmap *SyncMap[K, V]) Values() iter.Seq[V] {
+	return func(yield func(value V) bool) {
+		syncmap.ForEach(func(key K, value V) bool {
+			return yield(value)
+		})
+	}
+}
+
+// Clear removes all items from the map
+func (syncmap *SyncMap[K, V]) Clear() {
 	syncmap.mu.Lock()
-	keyList := []K{}
-	for key := range syncmap.value {
-		keyList = append(keyList, key)
+	defer syncmap.unlock()
+
+	if syncmap.watching.Load() != 0 {
+		for key, val := range syncmap.value {
+			syncmap.events = append(syncmap.events, SyncMapEvent[K, V]{Op: WATCH_DEL, Key: key, Old: val, Loaded: true})
+		}
+	}
+
+	syncmap.value = map[K]V{}
+	syncmap.order = nil
+	syncmap.dirty = true
+}
+
+// Clone returns a shallow copy of the map
+//
+// the copy uses the same locking mode as the original (see `NewReadMap` and `NewOrderedMap`)
+func (syncmap *SyncMap[K, V]) Clone() *SyncMap[K, V] {
+	value, unlock := syncmap.view()
+	defer unlock()
+
+	clone := &SyncMap[K, V]{
+		value:   maps.Clone(value),
+		cow:     syncmap.cow,
+		ordered: syncmap.ordered,
+		order:   slices.Clone(syncmap.order),
+	}
+	if clone.cow {
+		clone.publish()
+	}
+	return clone
+}
+
+// lock locks the map for writing
+func (syncmap *SyncMap[K, V]) lock() {
+	syncmap.mu.Lock()
+
+	if syncmap.value == nil {
+		// zero value map
+		syncmap.value = map[K]V{}
+	}
+}
+
+// unlock unlocks the map after writing
+//
+// if the map is copy-on-write, and keys were added or removed, a new copy of the map is published to readers
+//
+// any changes made while the map was locked are queued, and sent to watchers after it is unlocked,
+// in the same order they were made
+func (syncmap *SyncMap[K, V]) unlock() {
+	if syncmap.cow && syncmap.dirty {
+		syncmap.publish()
+	}
+
+	if len(syncmap.events) == 0 {
+		syncmap.mu.Unlock()
+		return
 	}
+
+	syncmap.queueMu.Lock()
+	syncmap.queue = append(syncmap.queue, syncmap.events...)
+	syncmap.queueMu.Unlock()
+
+	syncmap.events = nil
 	syncmap.mu.Unlock()
-	
-	for _, key := range keyList {
-		syncmap.mu.Lock()
 
-		if hasVal, ok := syncmap.hasVal[key]; !ok || !hasVal {
-			delete(syncmap.value, key)
-			delete(syncmap.hasVal, key)
-			syncmap.mu.Unlock()
-			continue
+	syncmap.drain()
+}
+
+// drain sends the queued events to watchers, until the queue is empty
+//
+// the map is not locked while the events are sent,
+// so a watcher can still use the map while a writer waits for it to read an event
+func (syncmap *SyncMap[K, V]) drain() {
+	syncmap.watchMu.Lock()
+	defer syncmap.watchMu.Unlock()
+
+	for {
+		syncmap.queueMu.Lock()
+		events := syncmap.queue
+		syncmap.queue = nil
+		syncmap.queueMu.Unlock()
+
+		if len(events) == 0 {
+			return
 		}
 
-		var val V
-		if v, ok := syncmap.value[key]; ok {
-			val = v
+		syncmap.notify(events)
+	}
+}
+
+// store sets a key in the map, and adds new keys to the insertion order
+//
+// note: the map must already be locked for writing
+func (syncmap *SyncMap[K, V]) store(key K, value V) {
+	old, loaded := syncmap.value[key]
+
+	if syncmap.ordered && !loaded {
+		syncmap.order = append(syncmap.order, key)
+	}
+
+	syncmap.value[key] = value
+
+	if syncmap.cow {
+		if entry, ok := (*syncmap.read.Load())[key]; ok {
+			entry.value.Store(&value)
+		} else {
+			syncmap.dirty = true
 		}
+	}
 
-		syncmap.mu.Unlock()
+	if syncmap.watching.Load() != 0 {
+		syncmap.events = append(syncmap.events, SyncMapEvent[K, V]{Op: WATCH_SET, Key: key, Old: old, New: value, Loaded: loaded})
+	}
+}
 
-		if !cb(key, val) {
-			break
+// remove deletes a key from the map, and from the insertion order
+//
+// note: the map must already be locked for writing
+func (syncmap *SyncMap[K, V]) remove(key K) {
+	old, loaded := syncmap.value[key]
+	if !loaded {
+		return
+	}
+
+	if syncmap.ordered {
+		if i := slices.Index(syncmap.order, key); i != -1 {
+			syncmap.order = slices.Delete(syncmap.order, i, i+1)
 		}
 	}
+
+	delete(syncmap.value, key)
+	syncmap.dirty = syncmap.cow
+
+	if syncmap.watching.Load() != 0 {
+		syncmap.events = append(syncmap.events, SyncMapEvent[K, V]{Op: WATCH_DEL, Key: key, Old: old, Loaded: true})
+	}
+}
+
+// view returns the current map for reading, and a function to release it
+//
+// the returned map must not be modified
+func (syncmap *SyncMap[K, V]) view() (map[K]V, func()) {
+	syncmap.mu.RLock()
+	return syncmap.value, syncmap.mu.RUnlock
+}
+
+// publish makes a new read only copy of a copy-on-write map
+//
+// note: the map must already be locked for writing (or not shared yet)
+func (syncmap *SyncMap[K, V]) publish() {
+	read := make(map[K]*syncMapEntry[V], len(syncmap.value))
+	for key, val := range syncmap.value {
+		entry := &syncMapEntry[V]{}
+		entry.value.Store(&val)
+		read[key] = entry
+	}
+
+	syncmap.read.Store(&read)
+	syncmap.dirty = false
 }
@@ -0,0 +1,150 @@
+package goutil
+
+import (
+	"slices"
+	"sync"
+)
+
+type SyncMultiMap[K Hashable, V any] struct {
+	value map[K][]V
+	mu    sync.RWMutex
+}
+
+// NewMultiMap creates a new synchronized map that holds many values per key,
+// and uses sync.RWMutex behind the scenes
+func NewMultiMap[K Hashable, V any]() *SyncMultiMap[K, V] {
+	return &SyncMultiMap[K, V]{
+		value: map[K][]V{},
+	}
+}
+
+// Get returns a copy of the values for a key
+func (multimap *SyncMultiMap[K, V]) Get(key K) ([]V, bool) {
+	multimap.mu.RLock()
+	defer multimap.mu.RUnlock()
+
+	val, ok := multimap.value[key]
+	return slices.Clone(val), ok
+}
+
+// Add appends values to a key
+//
+// if no values are given, the map is left unchanged
+func (multimap *SyncMultiMap[K, V]) Add(key K, values ...V) {
+	if len(values) == 0 {
+		return
+	}
+
+	multimap.mu.Lock()
+	defer multimap.mu.Unlock()
+
+	if multimap.value == nil {
+		// zero value map
+		multimap.value = map[K][]V{}
+	}
+
+	multimap.value[key] = append(multimap.value[key], values...)
+}
+
+// Set replaces all the values of a key
+//
+// if no values are given, the key is removed
+func (multimap *SyncMultiMap[K, V]) Set(key K, values ...V) {
+	multimap.mu.Lock()
+	defer multimap.mu.Unlock()
+
+	if len(values) == 0 {
+		delete(multimap.value, key)
+		return
+	}
+
+	if multimap.value == nil {
+		// zero value map
+		multimap.value = map[K][]V{}
+	}
+
+	multimap.value[key] = slices.Clone(values)
+}
+
+// Del removes a key and all of its values
+func (multimap *SyncMultiMap[K, V]) Del(key K) {
+	multimap.mu.Lock()
+	defer multimap.mu.Unlock()
+
+	delete(multimap.value, key)
+}
+
+// DelFunc removes the values of a key that the callback function returns true for
+//
+// the key is removed when it has no values left
+//
+// @return: the number of values removed
+func (multimap *SyncMultiMap[K, V]) DelFunc(key K, cb func(value V) bool) int {
+	multimap.mu.Lock()
+	defer multimap.mu.Unlock()
+
+	val, ok := multimap.value[key]
+	if !ok {
+		return 0
+	}
+
+	size := len(val)
+	val = slices.DeleteFunc(val, cb)
+
+	if len(val) == 0 {
+		delete(multimap.value, key)
+	} else {
+		multimap.value[key] = val
+	}
+
+	return size - len(val)
+}
+
+// Has returns true if a key has any values
+func (multimap *SyncMultiMap[K, V]) Has(key K) bool {
+	multimap.mu.RLock()
+	defer multimap.mu.RUnlock()
+
+	_, ok := multimap.value[key]
+	return ok
+}
+
+// Len returns the number of keys in the map
+func (multimap *SyncMultiMap[K, V]) Len() int {
+	multimap.mu.RLock()
+	defer multimap.mu.RUnlock()
+
+	return len(multimap.value)
+}
+
+// Count returns the number of values for a key
+func (multimap *SyncMultiMap[K, V]) Count(key K) int {
+	multimap.mu.RLock()
+	defer multimap.mu.RUnlock()
+
+	return len(multimap.value[key])
+}
+
+// ForEach runs a callback function for each key, with a copy of its values
+//
+// in the callback, return true to continue, and false to break the loop
+func (multimap *SyncMultiMap[K, V]) ForEach(cb func(key K, values []V) bool) {
+	multimap.mu.RLock()
+	keyList := make([]K, 0, len(multimap.value))
+	for key := range multimap.value {
+		keyList = append(keyList, key)
+	}
+	multimap.mu.RUnlock()
+
+	for _, key := range keyList {
+		val, ok := multimap.Get(key)
+		if !ok {
+			// removed since the key list was made
+			continue
+		}
+
+		if !cb(key, val) {
+			break
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package goutil
+
+import (
+	"iter"
+	"sync"
+)
+
+type SyncSet[K Hashable] struct {
+	value map[K]struct{}
+	mu    sync.RWMutex
+}
+
+// NewSet creates a new synchronized set that uses sync.RWMutex behind the scenes
+func NewSet[K Hashable](items ...K) *SyncSet[K] {
+	set := &SyncSet[K]{
+		value: make(map[K]struct{}, len(items)),
+	}
+
+	for _, item := range items {
+		set.value[item] = struct{}{}
+	}
+
+	return set
+}
+
+// Add adds items to the set
+func (set *SyncSet[K]) Add(items ...K) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	if set.value == nil {
+		// zero value set
+		set.value = map[K]struct{}{}
+	}
+
+	for _, item := range items {
+		set.value[item] = struct{}{}
+	}
+}
+
+// Remove removes items from the set
+func (set *SyncSet[K]) Remove(items ...K) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	for _, item := range items {
+		delete(set.value, item)
+	}
+}
+
+// Has returns true if an item exists in the set
+func (set *SyncSet[K]) Has(item K) bool {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+
+	_, ok := set.value[item]
+	return ok
+}
+
+// Len returns the number of items in the set
+func (set *SyncSet[K]) Len() int {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+
+	return len(set.value)
+}
+
+// Items returns a list of the items in the set
+func (set *SyncSet[K]) Items() []K {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+
+	items := make([]K, 0, len(set.value))
+	for item := range set.value {
+		items = append(items, item)
+	}
+	return items
+}
+
+// ForEach runs a callback function for each item
+//
+// in the callback, return true to continue, and false to break the loop
+func (set *SyncSet[K]) ForEach(cb func(item K) bool) {
+	for _, item := range set.Items() {
+		if !set.Has(item) {
+			// removed since the item list was made
+			continue
+		}
+
+		if !cb(item) {
+			break
+		}
+	}
+}
+
+// All returns an iterator over each item
+//
+// like `ForEach`, the set is not locked while the loop body runs
+func (set *SyncSet[K]) All() iter.Seq[K] {
+	return func(yield func(item K) bool) {
+		set.ForEach(yield)
+	}
+}
+
+// Union returns a new set with the items that are in either set
+func (set *SyncSet[K]) Union(other *SyncSet[K]) *SyncSet[K] {
+	res := NewSet(set.Items()...)
+	res.Add(other.Items()...)
+	return res
+}
+
+// Intersect returns a new set with the items that are in both sets
+func (set *SyncSet[K]) Intersect(other *SyncSet[K]) *SyncSet[K] {
+	// copy the other set first, so both sets are never locked at once
+	items := other.Items()
+
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+
+	res := NewSet[K]()
+	for _, item := range items {
+		if _, ok := set.value[item]; ok {
+			res.value[item] = struct{}{}
+		}
+	}
+	return res
+}
+
+// Difference returns a new set with the items that are in this set, and not in the other set
+func (set *SyncSet[K]) Difference(other *SyncSet[K]) *SyncSet[K] {
+	res := NewSet(set.Items()...)
+	res.Remove(other.Items()...)
+	return res
+}
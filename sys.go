@@ -3,6 +3,8 @@ package goutil
 import (
 	"math"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
@@ -24,6 +26,135 @@ func SysFreeMemory() float64 {
 	return math.Round(float64(uint64(in.Freeram)*uint64(in.Unit))/1024/1024*100) / 100
 }
 
+// CgroupFreeMemory returns the amount of memory available to the cgroup in megabytes
+//
+// returns false if the process is not limited by a cgroup memory limit
+//
+// both cgroup v2 and v1 are supported, and the limits of the process's own cgroup
+// (from /proc/self/cgroup) and each of its parents are checked, so the smallest amount is returned
+func CgroupFreeMemory() (float64, bool) {
+	root, cgroup := "/sys/fs/cgroup", ""
+	limit, usage, inactive := "memory.max", "memory.current", "inactive_file"
+	if _, err := os.Stat(root + "/cgroup.controllers"); err != nil {
+		root = "/sys/fs/cgroup/memory"
+		limit, usage, inactive = "memory.limit_in_bytes", "memory.usage_in_bytes", "total_inactive_file"
+	}
+
+	if b, err := os.ReadFile("/proc/self/cgroup"); err == nil {
+		cgroup = selfCgroupPath(string(b), root != "/sys/fs/cgroup")
+	}
+
+	// without a cgroup namespace, the path may not exist under the mount,
+	// so start from the closest parent that does
+	dir := filepath.Join(root, cgroup)
+	for dir != root && strings.HasPrefix(dir, root) {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	free, found := uint64(0), false
+	for {
+		if n, ok := cgroupFree(dir, limit, usage, inactive); ok && (!found || n < free) {
+			free, found = n, true
+		}
+
+		if dir == root || !strings.HasPrefix(dir, root) {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	if !found {
+		return 0, false
+	}
+
+	if free == 0 {
+		// 0 is treated as unknown, so report the smallest amount instead
+		return 0.01, true
+	}
+	return FormatMemoryUsage(free), true
+}
+
+// selfCgroupPath returns the cgroup path of the process, from the content of /proc/self/cgroup
+//
+// @v1: find the path of the memory controller, instead of the unified (v2) hierarchy
+func selfCgroupPath(content string, v1 bool) string {
+	for _, line := range strings.Split(content, "\n") {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		if !v1 && parts[0] == "0" && parts[1] == "" {
+			return parts[2]
+		}
+
+		if v1 && slices.Contains(strings.Split(parts[1], ","), "memory") {
+			return parts[2]
+		}
+	}
+
+	return ""
+}
+
+// cgroupFree returns the number of bytes available to a cgroup directory, if it has a memory limit
+func cgroupFree(dir string, limit string, usage string, inactive string) (uint64, bool) {
+	b, err := os.ReadFile(filepath.Join(dir, limit))
+	if err != nil {
+		return 0, false
+	}
+	max, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil || max >= 1<<60 {
+		// "max" or a huge number means there is no limit
+		return 0, false
+	}
+
+	b, err = os.ReadFile(filepath.Join(dir, usage))
+	if err != nil {
+		return 0, false
+	}
+	used, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	// page cache that can be reclaimed is not counted as used
+	if b, err := os.ReadFile(filepath.Join(dir, "memory.stat")); err == nil {
+		for _, line := range strings.Split(string(b), "\n") {
+			if val, ok := strings.CutPrefix(line, inactive+" "); ok {
+				if n, err := strconv.ParseUint(strings.TrimSpace(val), 10, 64); err == nil && n <= used {
+					used -= n
+				}
+				break
+			}
+		}
+	}
+
+	if used >= max {
+		return 0, true
+	}
+	return max - used, true
+}
+
+// InotifyWatchLimit returns the maximum number of inotify watches each user can have
+//
+// returns false if the limit cannot be read from /proc/sys/fs/inotify/max_user_watches
+func InotifyWatchLimit() (int, bool) {
+	b, err := os.ReadFile("/proc/sys/fs/inotify/max_user_watches")
+	if err != nil {
+		return 0, false
+	}
+
+	limit, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, false
+	}
+	return limit, true
+}
+
 // FormatMemoryUsage converts bytes to megabytes
 func FormatMemoryUsage(b uint64) float64 {
 	return math.Round(float64(b)/1024/1024*100) / 100